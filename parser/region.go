@@ -2,23 +2,130 @@ package parser
 
 import (
 	"fmt"
-	"github.com/emilyselwood/tiffhax/payload"
-	"strconv"
+	"strings"
 )
 
+/*
+Region is a part of a file that has been identified. Regions are used to lay out the file, finding where new parts go
+and splitting up the unknown space around them.
+*/
 type Region interface {
 	Split(start int64, end int64, newBit Region) error
-	Render() ([]payload.Section, error)
+	Range() (int64, int64)
 	Find(offset int64) (Region, error)
 	Contains(offset int64) bool
 	ContainsRegion(start int64, end int64) bool
+	Shared() *Sharing
+}
+
+/*
+Sharing records the other regions that claim some of the same bytes as a region. Aliases cover exactly the same bytes,
+for example when several IFDs point at one array of values. Conflicts only partly overlap, which a well formed file
+should not have.
+*/
+type Sharing struct {
+	Aliases   []Region
+	Conflicts []Region
+}
+
+/*
+Shared gives access to the sharing information of a region. Regions get this by embedding Sharing.
+*/
+func (s *Sharing) Shared() *Sharing {
+	return s
+}
+
+/*
+OverlapError is returned by Insert when a region partly overlaps regions already in the tree.
+*/
+type OverlapError struct {
+	Start     int64
+	End       int64
+	Claimants []Region
+}
+
+func (e *OverlapError) Error() string {
+	var ranges []string
+	for _, c := range e.Claimants {
+		start, end := c.Range()
+		ranges = append(ranges, fmt.Sprintf("%v to %v", start, end))
+	}
+	return fmt.Sprintf("region %v to %v overlaps regions already found at %v", e.Start, e.End, strings.Join(ranges, ", "))
+}
+
+/*
+Insert places a new region in the tree under top. A region covering exactly the same bytes as one already in the tree
+is recorded as an alias of it rather than being placed, as is one covering the same bytes as a region that partly
+overlaps others. A region that partly overlaps others is recorded as a conflict on all of them, kept in the Overlapping
+list of top so it can still be shown, and an OverlapError is returned.
+*/
+func Insert(top *Unknown, newBit Region) error {
+	start, end := newBit.Range()
+	if end <= start {
+		return fmt.Errorf("region at %v is empty", start)
+	}
+	if end > top.End {
+		return fmt.Errorf("region %v to %v runs past the end of the file at %v", start, end, top.End)
+	}
+	target, err := top.Find(start)
+	if err != nil {
+		return fmt.Errorf("could not insert new region, %v", err)
+	}
+
+	if u, ok := target.(*Unknown); ok {
+		if u.ContainsRegion(start, end) {
+			if err := u.Split(start, end, newBit); err != nil {
+				return fmt.Errorf("could not split, %v", err)
+			}
+			return nil
+		}
+	} else if targetStart, targetEnd := target.Range(); targetStart == start && targetEnd == end {
+		target.Shared().Aliases = append(target.Shared().Aliases, newBit)
+		newBit.Shared().Aliases = append(newBit.Shared().Aliases, target)
+		return nil
+	}
+
+	// a region covering the same bytes as one that partly overlaps others is an alias of it, not another conflict
+	if same, ok := top.overlappingAt[[2]int64{start, end}]; ok {
+		same.Shared().Aliases = append(same.Shared().Aliases, newBit)
+		newBit.Shared().Aliases = append(newBit.Shared().Aliases, same)
+		return nil
+	}
+
+	// step through what is already in the tree from the start of the new region to its end
+	result := OverlapError{Start: start, End: end}
+	for position := start; position < end; {
+		r, err := top.Find(position)
+		if err != nil {
+			return fmt.Errorf("could not find what overlaps new region, %v", err)
+		}
+		if _, ok := r.(*Unknown); !ok {
+			r.Shared().Conflicts = append(r.Shared().Conflicts, newBit)
+			newBit.Shared().Conflicts = append(newBit.Shared().Conflicts, r)
+			result.Claimants = append(result.Claimants, r)
+		}
+		_, position = r.Range()
+	}
+	top.Overlapping = append(top.Overlapping, newBit)
+	if top.overlappingAt == nil {
+		top.overlappingAt = map[[2]int64]Region{}
+	}
+	top.overlappingAt[[2]int64{start, end}] = newBit
+	return &result
 }
 
 type Unknown struct {
+	Sharing
+
 	Start int64
 	End   int64
 
 	Children []Region
+	// Overlapping holds the regions that partly overlap others so could not be placed in the tree, in the order they
+	// were inserted. Only the top of the tree uses it.
+	Overlapping []Region
+	// overlappingAt finds the regions in Overlapping by their start and end.
+	overlappingAt map[[2]int64]Region
 }
 
 func (u *Unknown) Contains(offset int64) bool {
@@ -93,19 +200,43 @@ func (u *Unknown) Split(start int64, end int64, newBit Region) error {
 	return nil
 }
 
-func (u *Unknown) Render() ([]payload.Section, error) {
-	if len(u.Children) > 0 {
-		var result []payload.Section
-		for _, c := range u.Children {
-			part, err := c.Render()
-			if err != nil {
-				return nil, err
-			}
-			result = append(result, part...)
+/*
+Range returns the start of the region and one past its end.
+*/
+func (u *Unknown) Range() (int64, int64) {
+	return u.Start, u.End
+}
+
+/*
+Gaps returns the parts of this region that have not been claimed by anything else.
+*/
+func (u *Unknown) Gaps() []*Unknown {
+	if len(u.Children) == 0 {
+		return []*Unknown{u}
+	}
+	var result []*Unknown
+	for _, c := range u.Children {
+		if child, ok := c.(*Unknown); ok {
+			result = append(result, child.Gaps()...)
+		}
+	}
+	return result
+}
+
+/*
+Leaves returns the regions that make up this one in file order, stepping into unknown regions that have been split.
+*/
+func (u *Unknown) Leaves() []Region {
+	if len(u.Children) == 0 {
+		return []Region{u}
+	}
+	var result []Region
+	for _, c := range u.Children {
+		if child, ok := c.(*Unknown); ok {
+			result = append(result, child.Leaves()...)
+		} else {
+			result = append(result, c)
 		}
-		return result, nil
-	} else {
-		// TODO better rendering.
-		return []payload.Section{&payload.General{Start: u.Start, End: u.End, Id: strconv.FormatInt(u.Start, 10), Text: "Un-parsed section"}}, nil
 	}
+	return result
 }
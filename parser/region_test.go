@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+)
+
+// block is a region that can not be split, standing in for the parts of a file in the tests.
+type block struct {
+	Sharing
+	Start int64
+	End   int64
+}
+
+func (b *block) Split(start int64, end int64, newBit Region) error {
+	return fmt.Errorf("block can not be split")
+}
+
+func (b *block) Range() (int64, int64) {
+	return b.Start, b.End
+}
+
+func (b *block) Find(offset int64) (Region, error) {
+	return b, nil
+}
+
+func (b *block) Contains(offset int64) bool {
+	return b.Start <= offset && offset < b.End
+}
+
+func (b *block) ContainsRegion(start int64, end int64) bool {
+	return b.Start <= start && end <= b.End
+}
+
+func ranges(regions []Region) [][2]int64 {
+	var result [][2]int64
+	for _, r := range regions {
+		start, end := r.Range()
+		result = append(result, [2]int64{start, end})
+	}
+	return result
+}
+
+func TestInsert(t *testing.T) {
+	root := &Unknown{Start: 0, End: 100}
+	for _, b := range []*block{{Start: 10, End: 20}, {Start: 0, End: 5}, {Start: 90, End: 100}, {Start: 20, End: 30}} {
+		if err := Insert(root, b); err != nil {
+			t.Fatalf("could not insert %v to %v: %v", b.Start, b.End, err)
+		}
+	}
+
+	expected := [][2]int64{{0, 5}, {5, 10}, {10, 20}, {20, 30}, {30, 90}, {90, 100}}
+	if actual := ranges(root.Leaves()); fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("leaves were %v expected %v", actual, expected)
+	}
+
+	gaps := []Region{}
+	for _, g := range root.Gaps() {
+		gaps = append(gaps, g)
+	}
+	expected = [][2]int64{{5, 10}, {30, 90}}
+	if actual := ranges(gaps); fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("gaps were %v expected %v", actual, expected)
+	}
+
+	found, err := root.Find(15)
+	if err != nil || found.(*block).Start != 10 {
+		t.Errorf("find 15 gave %v, %v", found, err)
+	}
+	if _, err := root.Find(100); err == nil {
+		t.Errorf("find past the end should fail")
+	}
+}
+
+func TestInsertShared(t *testing.T) {
+	root := &Unknown{Start: 0, End: 100}
+	first := &block{Start: 10, End: 20}
+	second := &block{Start: 10, End: 20}
+	if err := Insert(root, first); err != nil {
+		t.Fatalf("could not insert: %v", err)
+	}
+	if err := Insert(root, second); err != nil {
+		t.Fatalf("could not insert the same range again: %v", err)
+	}
+	if len(first.Aliases) != 1 || first.Aliases[0] != second || len(second.Aliases) != 1 || second.Aliases[0] != first {
+		t.Errorf("the two blocks should be aliases of each other")
+	}
+	if leaves := root.Leaves(); len(leaves) != 3 {
+		t.Errorf("the alias should not be placed, got %v", ranges(leaves))
+	}
+}
+
+func TestInsertOverlap(t *testing.T) {
+	root := &Unknown{Start: 0, End: 100}
+	first := &block{Start: 10, End: 20}
+	second := &block{Start: 20, End: 30}
+	overlap := &block{Start: 15, End: 25}
+	for _, b := range []*block{first, second} {
+		if err := Insert(root, b); err != nil {
+			t.Fatalf("could not insert: %v", err)
+		}
+	}
+
+	err := Insert(root, overlap)
+	overlapErr, ok := err.(*OverlapError)
+	if !ok {
+		t.Fatalf("expected an overlap error, got %v", err)
+	}
+	if len(overlapErr.Claimants) != 2 {
+		t.Errorf("expected both blocks to be listed, got %v", ranges(overlapErr.Claimants))
+	}
+	if len(first.Conflicts) != 1 || len(second.Conflicts) != 1 || len(overlap.Conflicts) != 2 {
+		t.Errorf("conflicts should be recorded on all the blocks")
+	}
+	if len(root.Overlapping) != 1 || root.Overlapping[0] != overlap {
+		t.Errorf("the overlapping block should be kept to show, got %v", ranges(root.Overlapping))
+	}
+}
+
+func TestInsertSharedOverlap(t *testing.T) {
+	root := &Unknown{Start: 0, End: 100}
+	first := &block{Start: 10, End: 20}
+	overlap := &block{Start: 15, End: 25}
+	same := &block{Start: 15, End: 25}
+	if err := Insert(root, first); err != nil {
+		t.Fatalf("could not insert: %v", err)
+	}
+	if _, ok := Insert(root, overlap).(*OverlapError); !ok {
+		t.Fatalf("expected an overlap error")
+	}
+
+	if err := Insert(root, same); err != nil {
+		t.Fatalf("the same range as an overlapping block should be an alias, got %v", err)
+	}
+	if len(overlap.Aliases) != 1 || overlap.Aliases[0] != same || len(same.Aliases) != 1 || same.Aliases[0] != overlap {
+		t.Errorf("the two overlapping blocks should be aliases of each other")
+	}
+	if len(first.Conflicts) != 1 || len(same.Conflicts) != 0 {
+		t.Errorf("the alias should not add a conflict, first has %v and the alias %v", len(first.Conflicts), len(same.Conflicts))
+	}
+	if len(root.Overlapping) != 1 {
+		t.Errorf("the alias should not get a row of its own, got %v", ranges(root.Overlapping))
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		block *block
+	}{
+		{"empty", &block{Start: 10, End: 10}},
+		{"past the end", &block{Start: 90, End: 110}},
+		{"outside", &block{Start: 200, End: 210}},
+		{"negative", &block{Start: -10, End: 10}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := &Unknown{Start: 0, End: 100}
+			if err := Insert(root, test.block); err == nil {
+				t.Errorf("expected an error")
+			}
+			if len(root.Children) != 0 {
+				t.Errorf("nothing should have been inserted")
+			}
+		})
+	}
+}
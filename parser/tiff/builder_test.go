@@ -0,0 +1,412 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+/*
+testEntry is a field to write into a test IFD. Values that do not fit in the field are written after the IFD.
+*/
+type testEntry struct {
+	ID    uint16
+	DType uint16
+	Count uint64
+	Value []byte
+}
+
+/*
+testBuilder writes small tiff files for the tests, a header followed by whatever IFDs and blocks of bytes are added.
+*/
+type testBuilder struct {
+	order binary.ByteOrder
+	big   bool
+	buf   []byte
+	// ifds holds where each IFD starts in the order they were added
+	ifds []int64
+}
+
+func newTestBuilder(order binary.ByteOrder, big bool) *testBuilder {
+	b := testBuilder{order: order, big: big}
+	if order == binary.BigEndian {
+		b.buf = append(b.buf, 'M', 'M')
+	} else {
+		b.buf = append(b.buf, 'I', 'I')
+	}
+	if big {
+		b.buf = append(b.buf, b.shorts(43, 8, 0)...)
+		b.buf = append(b.buf, b.long8s(0)...)
+	} else {
+		b.buf = append(b.buf, b.shorts(42)...)
+		b.buf = append(b.buf, b.longs(0)...)
+	}
+	return &b
+}
+
+func (b *testBuilder) offsetSize() int {
+	if b.big {
+		return 8
+	}
+	return 4
+}
+
+func (b *testBuilder) offset(v uint64) []byte {
+	if b.big {
+		return b.long8s(v)
+	}
+	return b.longs(uint32(v))
+}
+
+/*
+add appends a block of bytes, starting on a word boundary, and returns where it starts.
+*/
+func (b *testBuilder) add(data []byte) int64 {
+	if len(b.buf)%2 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	start := int64(len(b.buf))
+	b.buf = append(b.buf, data...)
+	return start
+}
+
+/*
+ifd appends an IFD with its out of line values straight after it and returns where it starts.
+*/
+func (b *testBuilder) ifd(entries []testEntry, next int64) int64 {
+	countSize, fieldSize := 2, 12
+	if b.big {
+		countSize, fieldSize = 8, 20
+	}
+	start := b.add(nil)
+	valuesStart := start + int64(countSize+len(entries)*fieldSize+b.offsetSize())
+
+	var values []byte
+	var fields []byte
+	for _, e := range entries {
+		fields = append(fields, b.shorts(e.ID, e.DType)...)
+		if b.big {
+			fields = append(fields, b.long8s(e.Count)...)
+		} else {
+			fields = append(fields, b.longs(uint32(e.Count))...)
+		}
+		if len(e.Value) > b.offsetSize() {
+			if len(values)%2 != 0 {
+				values = append(values, 0)
+			}
+			fields = append(fields, b.offset(uint64(valuesStart)+uint64(len(values)))...)
+			values = append(values, e.Value...)
+		} else {
+			slot := make([]byte, b.offsetSize())
+			copy(slot, e.Value)
+			fields = append(fields, slot...)
+		}
+	}
+
+	if b.big {
+		b.buf = append(b.buf, b.long8s(uint64(len(entries)))...)
+	} else {
+		b.buf = append(b.buf, b.shorts(uint16(len(entries)))...)
+	}
+	b.buf = append(b.buf, fields...)
+	b.buf = append(b.buf, b.offset(uint64(next))...)
+	b.buf = append(b.buf, values...)
+	b.ifds = append(b.ifds, start)
+	return start
+}
+
+func (b *testBuilder) setFirstIFD(offset int64) {
+	if b.big {
+		b.patchOffset(8, uint64(offset))
+	} else {
+		b.patchOffset(4, uint64(offset))
+	}
+}
+
+/*
+setNext changes the next ifd pointer of the IFD at ifdStart.
+*/
+func (b *testBuilder) setNext(ifdStart int64, next int64) {
+	var count uint64
+	if b.big {
+		count = b.order.Uint64(b.buf[ifdStart:])
+	} else {
+		count = uint64(b.order.Uint16(b.buf[ifdStart:]))
+	}
+	b.patchOffset(b.fieldStart(ifdStart, int(count)), uint64(next))
+}
+
+/*
+setFieldValue changes the value or offset of the field at index in the IFD at ifdStart.
+*/
+func (b *testBuilder) setFieldValue(ifdStart int64, index int, v uint64) {
+	b.patchOffset(b.fieldStart(ifdStart, index+1)-int64(b.offsetSize()), v)
+}
+
+func (b *testBuilder) fieldStart(ifdStart int64, index int) int64 {
+	if b.big {
+		return ifdStart + 8 + int64(index)*20
+	}
+	return ifdStart + 2 + int64(index)*12
+}
+
+func (b *testBuilder) patchOffset(at int64, v uint64) {
+	copy(b.buf[at:], b.offset(v))
+}
+
+func (b *testBuilder) bytes() []byte {
+	return b.buf
+}
+
+func (b *testBuilder) reader() *bytes.Reader {
+	return bytes.NewReader(b.buf)
+}
+
+func (b *testBuilder) shorts(values ...uint16) []byte {
+	var result []byte
+	for _, v := range values {
+		x := make([]byte, 2)
+		b.order.PutUint16(x, v)
+		result = append(result, x...)
+	}
+	return result
+}
+
+func (b *testBuilder) longs(values ...uint32) []byte {
+	var result []byte
+	for _, v := range values {
+		x := make([]byte, 4)
+		b.order.PutUint32(x, v)
+		result = append(result, x...)
+	}
+	return result
+}
+
+func (b *testBuilder) long8s(values ...uint64) []byte {
+	var result []byte
+	for _, v := range values {
+		x := make([]byte, 8)
+		b.order.PutUint64(x, v)
+		result = append(result, x...)
+	}
+	return result
+}
+
+func (b *testBuilder) doubles(values ...float64) []byte {
+	var result []byte
+	for _, v := range values {
+		result = append(result, b.long8s(math.Float64bits(v))...)
+	}
+	return result
+}
+
+func (b *testBuilder) short(id uint16, values ...uint16) testEntry {
+	return testEntry{ID: id, DType: 3, Count: uint64(len(values)), Value: b.shorts(values...)}
+}
+
+func (b *testBuilder) long(id uint16, values ...uint32) testEntry {
+	return testEntry{ID: id, DType: 4, Count: uint64(len(values)), Value: b.longs(values...)}
+}
+
+func (b *testBuilder) ascii(id uint16, value string) testEntry {
+	return testEntry{ID: id, DType: 2, Count: uint64(len(value) + 1), Value: append([]byte(value), 0)}
+}
+
+func (b *testBuilder) rational(id uint16, numerator uint32, denominator uint32) testEntry {
+	return testEntry{ID: id, DType: 5, Count: 1, Value: b.longs(numerator, denominator)}
+}
+
+/*
+stripTIFF builds a 4 by 8 pixel, 8 bit grey image stored in two strips of four rows, with a description and a
+resolution stored outside the IFD.
+*/
+func stripTIFF(order binary.ByteOrder, big bool) *testBuilder {
+	b := newTestBuilder(order, big)
+	first := b.add(bytes.Repeat([]byte{1}, 16))
+	second := b.add(bytes.Repeat([]byte{2}, 16))
+	ifd := b.ifd([]testEntry{
+		b.short(256, 4),
+		b.short(257, 8),
+		b.short(258, 8),
+		b.short(259, 1),
+		b.short(262, 1),
+		b.ascii(270, "hello world"),
+		b.long(273, uint32(first), uint32(second)),
+		b.short(278, 4),
+		b.short(279, 16, 16),
+		b.rational(282, 72, 1),
+	}, 0)
+	b.setFirstIFD(ifd)
+	return b
+}
+
+/*
+sharedStripsTIFF builds a chain of 20 one pixel wide images of 1000 rows, all with the same strip offsets and byte
+counts which point every strip at the same byte.
+*/
+func sharedStripsTIFF() *testBuilder {
+	b := newTestBuilder(binary.LittleEndian, false)
+	strip := b.add([]byte{7})
+	offsets := make([]uint16, 1000)
+	counts := make([]uint16, 1000)
+	for i := range offsets {
+		offsets[i] = uint16(strip)
+		counts[i] = 1
+	}
+	entries := []testEntry{
+		b.short(256, 1),
+		b.short(257, 1000),
+		b.short(258, 8),
+		b.short(273, offsets...),
+		b.short(278, 1),
+		b.short(279, counts...),
+	}
+	first := b.ifd(entries, 0)
+	b.setFirstIFD(first)
+	offsetsAt := uint64(b.order.Uint32(b.bytes()[b.fieldStart(first, 3)+8:]))
+	countsAt := uint64(b.order.Uint32(b.bytes()[b.fieldStart(first, 5)+8:]))
+	previous := first
+	for i := 1; i < 20; i++ {
+		next := b.ifd(entries, 0)
+		// drop the copy of the arrays written after the ifd and point at the first ones
+		b.buf = b.buf[:b.fieldStart(next, len(entries))+4]
+		b.setFieldValue(next, 3, offsetsAt)
+		b.setFieldValue(next, 5, countsAt)
+		b.setNext(previous, next)
+		previous = next
+	}
+	return b
+}
+
+/*
+geoTIFF builds a 4 by 4 pixel image with a GeoTIFF key directory. Its keys hold values in the directory itself, in the
+GeoDoubleParamsTag and in the GeoAsciiParamsTag, and the last key points past the end of the doubles. The top left
+pixel is tied to (100, 200) with pixels half a unit wide and a quarter of a unit tall.
+*/
+func geoTIFF(order binary.ByteOrder) *testBuilder {
+	b := newTestBuilder(order, false)
+	strip := b.add(bytes.Repeat([]byte{1}, 16))
+	ifd := b.ifd([]testEntry{
+		b.short(256, 4),
+		b.short(257, 4),
+		b.short(258, 8),
+		b.long(273, uint32(strip)),
+		b.short(278, 4),
+		b.short(279, 16),
+		{ID: 33550, DType: 12, Count: 3, Value: b.doubles(0.5, 0.25, 0)},
+		{ID: 33922, DType: 12, Count: 6, Value: b.doubles(0, 0, 0, 100, 200, 0)},
+		b.short(34735,
+			1, 1, 0, 5,
+			1024, 0, 1, 2,
+			2048, 0, 1, 4326,
+			2049, 34737, 7, 0,
+			2057, 34736, 1, 1,
+			2059, 34736, 1, 5,
+		),
+		{ID: 34736, DType: 12, Count: 2, Value: b.doubles(0.5, 6378137)},
+		b.ascii(34737, "WGS 84|"),
+	}, 0)
+	b.setFirstIFD(ifd)
+	return b
+}
+
+/*
+tiledTIFF builds a 6 by 4 pixel image stored as 3 by 2 tiles of 2 by 2 pixels with two planes stored separately.
+*/
+func tiledTIFF(order binary.ByteOrder) *testBuilder {
+	b := newTestBuilder(order, false)
+	var offsets []uint32
+	var counts []uint32
+	for i := 0; i < 12; i++ {
+		offsets = append(offsets, uint32(b.add(bytes.Repeat([]byte{byte(i)}, 4))))
+		counts = append(counts, 4)
+	}
+	ifd := b.ifd([]testEntry{
+		b.short(256, 6),
+		b.short(257, 4),
+		b.short(258, 8, 8),
+		b.short(277, 2),
+		b.short(284, 2),
+		b.short(322, 2),
+		b.short(323, 2),
+		b.long(324, offsets...),
+		b.long(325, counts...),
+	}, 0)
+	b.setFirstIFD(ifd)
+	return b
+}
+
+/*
+malformedTIFFs builds broken files, each with a single problem the parser should report rather than fail on.
+*/
+func malformedTIFFs() map[string][]byte {
+	result := map[string][]byte{}
+
+	b := stripTIFF(binary.LittleEndian, false)
+	b.setNext(b.ifds[0], b.ifds[0])
+	result["ifd loop"] = b.bytes()
+
+	b = newTestBuilder(binary.BigEndian, false)
+	ifd := b.ifd([]testEntry{b.long(34665, 0)}, 0)
+	b.setFieldValue(ifd, 0, uint64(ifd))
+	b.setFirstIFD(ifd)
+	result["exif ifd loop"] = b.bytes()
+
+	b = stripTIFF(binary.LittleEndian, false)
+	b.setNext(b.ifds[0], 1<<30)
+	result["next ifd past the end"] = b.bytes()
+
+	b = stripTIFF(binary.LittleEndian, false)
+	b.setFieldValue(b.ifds[0], 5, uint64(len(b.bytes())-4))
+	result["values past the end"] = b.bytes()
+
+	b = stripTIFF(binary.LittleEndian, false)
+	copy(b.buf[b.fieldStart(b.ifds[0], 5)+4:], b.longs(0xffffffff))
+	result["huge count"] = b.bytes()
+
+	b = stripTIFF(binary.BigEndian, true)
+	copy(b.buf[b.fieldStart(b.ifds[0], 5)+4:], b.long8s(1<<62))
+	result["huge big tiff count"] = b.bytes()
+
+	b = stripTIFF(binary.LittleEndian, true)
+	copy(b.buf[b.ifds[0]:], b.long8s(1<<40))
+	result["huge ifd count"] = b.bytes()
+
+	b = stripTIFF(binary.LittleEndian, true)
+	b.setFieldValue(b.ifds[0], 5, 0xfffffffffffffff0)
+	result["negative offset"] = b.bytes()
+
+	b = stripTIFF(binary.LittleEndian, false)
+	result["truncated ifd"] = b.bytes()[:b.fieldStart(b.ifds[0], 4)]
+
+	b = stripTIFF(binary.LittleEndian, false)
+	description := b.order.Uint32(b.bytes()[b.fieldStart(b.ifds[0], 5)+8:])
+	b.setFieldValue(b.ifds[0], 9, uint64(description+4))
+	result["overlapping values"] = b.bytes()
+
+	b = stripTIFF(binary.LittleEndian, false)
+	offsets := b.order.Uint32(b.bytes()[b.fieldStart(b.ifds[0], 6)+8:])
+	copy(b.buf[offsets:], b.longs(0x7fffffff))
+	result["strip past the end"] = b.bytes()
+
+	b = tiledTIFF(binary.LittleEndian)
+	b.setFieldValue(b.ifds[0], 5, 0)
+	result["zero tile width"] = b.bytes()
+
+	b = stripTIFF(binary.LittleEndian, false)
+	b.setFieldValue(b.ifds[0], 1, 0)
+	b.setFieldValue(b.ifds[0], 7, 0)
+	result["zero image length"] = b.bytes()
+
+	b = newTestBuilder(binary.LittleEndian, false)
+	strip := b.add(bytes.Repeat([]byte{1}, 16))
+	b.setFirstIFD(b.ifd([]testEntry{
+		b.short(256, 4),
+		b.short(258, 8),
+		b.long(273, uint32(strip)),
+		b.short(279, 16),
+	}, 0))
+	result["missing image length"] = b.bytes()
+
+	return result
+}
@@ -14,6 +14,10 @@ var DataTypeNames = map[uint16]string {
 	10: "srational",
 	11: "float",
 	12: "double",
+	13: "ifd",
+	16: "long8",
+	17: "slong8",
+	18: "ifd8",
 }
 
 var DataTypeSize = map[uint16]uint32 {
@@ -29,4 +33,8 @@ var DataTypeSize = map[uint16]uint32 {
 	10: 8,
 	11: 4,
 	12: 8,
+	13: 4,
+	16: 8,
+	17: 8,
+	18: 8,
 }
@@ -7,9 +7,12 @@ var FieldValueLookup = map[uint16]map[uint32]string{
 		3:     "CCITT Group 3",
 		4:     "CCITT Group 4",
 		5:     "LZW",
-		6:     "JPEG",
+		6:     "Old-style JPEG",
+		7:     "JPEG",
+		8:     "Adobe Deflate",
 		32771: "Uncompressed (deprecated)",
 		32773: "PackBits",
+		32946: "Deflate",
 	},
 	262: { // Photometric interpretation.
 		0: "WhiteIsZero",
@@ -26,6 +29,11 @@ var FieldValueLookup = map[uint16]map[uint32]string{
 		1: "Chunky",
 		2: "Planar",
 	},
+	317: { // Predictor
+		1: "None",
+		2: "Horizontal differencing",
+		3: "Floating point",
+	},
 	339 : { // SampleFormat
 		0: "Unknown",
 		1: "Unsigned Int",
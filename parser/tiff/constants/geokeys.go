@@ -0,0 +1,193 @@
+package constants
+
+import "fmt"
+
+// GeoTIFF key names and values cribbed from the OGC GeoTIFF standard
+// http://docs.opengeospatial.org/is/19-008r4/19-008r4.html
+
+var GeoKeyNames = map[uint16]string {
+	1024: "GTModelTypeGeoKey",
+	1025: "GTRasterTypeGeoKey",
+	1026: "GTCitationGeoKey",
+	2048: "GeographicTypeGeoKey",
+	2049: "GeogCitationGeoKey",
+	2050: "GeogGeodeticDatumGeoKey",
+	2051: "GeogPrimeMeridianGeoKey",
+	2052: "GeogLinearUnitsGeoKey",
+	2053: "GeogLinearUnitSizeGeoKey",
+	2054: "GeogAngularUnitsGeoKey",
+	2055: "GeogAngularUnitSizeGeoKey",
+	2056: "GeogEllipsoidGeoKey",
+	2057: "GeogSemiMajorAxisGeoKey",
+	2058: "GeogSemiMinorAxisGeoKey",
+	2059: "GeogInvFlatteningGeoKey",
+	2060: "GeogAzimuthUnitsGeoKey",
+	2061: "GeogPrimeMeridianLongGeoKey",
+	2062: "GeogTOWGS84GeoKey",
+	3072: "ProjectedCSTypeGeoKey",
+	3073: "PCSCitationGeoKey",
+	3074: "ProjectionGeoKey",
+	3075: "ProjCoordTransGeoKey",
+	3076: "ProjLinearUnitsGeoKey",
+	3077: "ProjLinearUnitSizeGeoKey",
+	3078: "ProjStdParallel1GeoKey",
+	3079: "ProjStdParallel2GeoKey",
+	3080: "ProjNatOriginLongGeoKey",
+	3081: "ProjNatOriginLatGeoKey",
+	3082: "ProjFalseEastingGeoKey",
+	3083: "ProjFalseNorthingGeoKey",
+	3084: "ProjFalseOriginLongGeoKey",
+	3085: "ProjFalseOriginLatGeoKey",
+	3086: "ProjFalseOriginEastingGeoKey",
+	3087: "ProjFalseOriginNorthingGeoKey",
+	3088: "ProjCenterLongGeoKey",
+	3089: "ProjCenterLatGeoKey",
+	3090: "ProjCenterEastingGeoKey",
+	3091: "ProjCenterNorthingGeoKey",
+	3092: "ProjScaleAtNatOriginGeoKey",
+	3093: "ProjScaleAtCenterGeoKey",
+	3094: "ProjAzimuthAngleGeoKey",
+	3095: "ProjStraightVertPoleLongGeoKey",
+	3096: "ProjRectifiedGridAngleGeoKey",
+	4096: "VerticalCSTypeGeoKey",
+	4097: "VerticalCitationGeoKey",
+	4098: "VerticalDatumGeoKey",
+	4099: "VerticalUnitsGeoKey",
+}
+
+var linearUnits = map[uint16]string {
+	9001: "Linear_Meter",
+	9002: "Linear_Foot",
+	9003: "Linear_Foot_US_Survey",
+	9030: "Linear_Mile_International_Nautical",
+	9036: "Linear_Kilometer",
+}
+
+var angularUnits = map[uint16]string {
+	9101: "Angular_Radian",
+	9102: "Angular_Degree",
+	9103: "Angular_Arc_Minute",
+	9104: "Angular_Arc_Second",
+	9105: "Angular_Grad",
+	9106: "Angular_Gon",
+	9107: "Angular_DMS",
+	9108: "Angular_DMS_Hemisphere",
+}
+
+var GeoKeyValueLookup = map[uint16]map[uint16]string {
+	1024: { // GTModelType
+		1: "ModelTypeProjected",
+		2: "ModelTypeGeographic",
+		3: "ModelTypeGeocentric",
+	},
+	1025: { // GTRasterType
+		1: "RasterPixelIsArea",
+		2: "RasterPixelIsPoint",
+	},
+	2052: linearUnits,
+	2054: angularUnits,
+	2060: angularUnits,
+	3075: { // ProjCoordTrans
+		1:  "CT_TransverseMercator",
+		2:  "CT_TransvMercator_Modified_Alaska",
+		3:  "CT_ObliqueMercator",
+		4:  "CT_ObliqueMercator_Laborde",
+		5:  "CT_ObliqueMercator_Rosenmund",
+		6:  "CT_ObliqueMercator_Spherical",
+		7:  "CT_Mercator",
+		8:  "CT_LambertConfConic_2SP",
+		9:  "CT_LambertConfConic_1SP",
+		10: "CT_LambertAzimEqualArea",
+		11: "CT_AlbersEqualArea",
+		12: "CT_AzimuthalEquidistant",
+		13: "CT_EquidistantConic",
+		14: "CT_Stereographic",
+		15: "CT_PolarStereographic",
+		16: "CT_ObliqueStereographic",
+		17: "CT_Equirectangular",
+		18: "CT_CassiniSoldner",
+		19: "CT_Gnomonic",
+		20: "CT_MillerCylindrical",
+		21: "CT_Orthographic",
+		22: "CT_Polyconic",
+		23: "CT_Robinson",
+		24: "CT_Sinusoidal",
+		25: "CT_VanDerGrinten",
+		26: "CT_NewZealandMapGrid",
+		27: "CT_TransvMercator_SouthOriented",
+	},
+	3076: linearUnits,
+	4099: linearUnits,
+}
+
+// GeoKeysWithEPSGCodes lists the keys whose short values are EPSG codes.
+var GeoKeysWithEPSGCodes = map[uint16]bool {
+	2048: true, // GeographicType
+	2050: true, // GeogGeodeticDatum
+	2051: true, // GeogPrimeMeridian
+	2056: true, // GeogEllipsoid
+	3072: true, // ProjectedCSType
+	3074: true, // Projection
+	4096: true, // VerticalCSType
+	4098: true, // VerticalDatum
+}
+
+// a few of the more common EPSG codes, this is no where near all of them.
+var EPSGNames = map[uint16]string {
+	2154:  "RGF93 / Lambert-93",
+	2193:  "NZGD2000 / New Zealand Transverse Mercator 2000",
+	3031:  "WGS 84 / Antarctic Polar Stereographic",
+	3035:  "ETRS89-extended / LAEA Europe",
+	3395:  "WGS 84 / World Mercator",
+	3413:  "WGS 84 / NSIDC Sea Ice Polar Stereographic North",
+	3857:  "WGS 84 / Pseudo-Mercator",
+	4202:  "AGD66",
+	4230:  "ED50",
+	4258:  "ETRS89",
+	4267:  "NAD27",
+	4269:  "NAD83",
+	4277:  "OSGB 1936",
+	4283:  "GDA94",
+	4326:  "WGS 84",
+	4490:  "China Geodetic Coordinate System 2000",
+	4612:  "JGD2000",
+	5070:  "NAD83 / Conus Albers",
+	5703:  "NAVD88 height",
+	5773:  "EGM96 height",
+	6230:  "European Datum 1950",
+	6258:  "European Terrestrial Reference System 1989",
+	6267:  "North American Datum 1927",
+	6269:  "North American Datum 1983",
+	6277:  "Ordnance Survey of Great Britain 1936",
+	6326:  "World Geodetic System 1984",
+	7001:  "Airy 1830",
+	7008:  "Clarke 1866",
+	7019:  "GRS 1980",
+	7022:  "International 1924",
+	7030:  "WGS 84",
+	8901:  "Greenwich",
+	27700: "OSGB 1936 / British National Grid",
+	32767: "user-defined",
+}
+
+/*
+EPSGName looks up the name of an EPSG code, including the UTM zone ranges which follow a pattern.
+*/
+func EPSGName(code uint16) (string, bool) {
+	if name, ok := EPSGNames[code]; ok {
+		return name, true
+	}
+	switch {
+	case code > 32600 && code <= 32660:
+		return fmt.Sprintf("WGS 84 / UTM zone %vN", code-32600), true
+	case code > 32700 && code <= 32760:
+		return fmt.Sprintf("WGS 84 / UTM zone %vS", code-32700), true
+	case code > 26900 && code <= 26923:
+		return fmt.Sprintf("NAD83 / UTM zone %vN", code-26900), true
+	case code > 25827 && code <= 25838:
+		return fmt.Sprintf("ETRS89 / UTM zone %vN", code-25800), true
+	case code > 28347 && code <= 28358:
+		return fmt.Sprintf("GDA94 / MGA zone %v", code-28300), true
+	}
+	return "", false
+}
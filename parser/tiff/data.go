@@ -4,36 +4,205 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/emilyselwood/tiffhax/parser"
-	"github.com/emilyselwood/tiffhax/parser/tiff/constants"
 	"github.com/emilyselwood/tiffhax/payload"
 	"html/template"
 	"io"
 )
 
 type Data struct {
-	IFD   *IFD
-	Start int64
-	End   int64
-	DType uint16
-	Count int64
-	I     int
+	parser.Sharing
+
+	IFD    *IFD
+	Start  int64
+	End    int64
+	DType  uint16
+	Count  int64
+	I      int
+	IsTile bool
+	// X and Y are the column and row of a tile in the image grid. Strips only have a row.
+	X     uint64
+	Y     uint64
+	Plane uint64
+	// Located is set when the position of the block in the image could be worked out, X, Y and Plane are 0 otherwise.
+	Located bool
+	// why the position could not be worked out
+	positionErr error
+	// Missing is how many bytes of the block are past the end of the file, End is cut short to the end of the file.
+	Missing uint64
+	// Head and Tail are the first and last bytes of the block, read to preview it. Tail is empty when Head holds it all.
+	Head []byte
+	Tail []byte
+	// Decoding is what was found decompressing the block, nil when it is not compressed in a way we can decode.
+	Decoding *Decoding
 }
 
+// how many bytes from each end of a data block are shown, the rest can be loaded on demand.
+const dataPreviewSize = 64
+
 func (d *Data) Parse(in io.ReadSeeker, order binary.ByteOrder) error {
-	byteCounts, err := d.fetchFieldValue(279, in, order) // strip byte counts
+	byteCounts, err := d.fetchFieldValue(d.ByteCountsFieldId())
+	if err != nil {
+		return fmt.Errorf("could not find byte counts field, %v", err)
+	}
+
+	d.End = d.Start + byteCounts
+
+	// the position is only used to label the block so it is still shown when it can not be worked out
+	d.positionErr = d.findPosition()
+	d.Located = d.positionErr == nil
+
+	return nil
+}
+
+/*
+readPreview reads the start and end of the block so the first few bytes can be shown without loading all of it.
+*/
+func (d *Data) readPreview(in io.ReadSeeker) error {
+	size := d.End - d.Start
+	if size <= 0 {
+		return nil
+	}
+
+	headSize := size
+	if size > 2*dataPreviewSize {
+		headSize = dataPreviewSize
+	}
+	head, err := readAt(in, d.Start, headSize)
 	if err != nil {
-		// try for the other field
-		byteCounts, err = d.fetchFieldValue(325, in, order)
+		return fmt.Errorf("could not read the start of the block, %v", err)
+	}
+	d.Head = head
+
+	if headSize < size {
+		tail, err := readAt(in, d.End-dataPreviewSize, dataPreviewSize)
 		if err != nil {
-			return fmt.Errorf("could not find rows per strip field, %v", err)
+			return fmt.Errorf("could not read the end of the block, %v", err)
 		}
+		d.Tail = tail
 	}
+	return nil
+}
 
-	d.End = d.Start + byteCounts
+func readAt(in io.ReadSeeker, start int64, size int64) ([]byte, error) {
+	if _, err := in.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	result := make([]byte, size)
+	if _, err := io.ReadFull(in, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+/*
+annotations returns the mark up of the compressed bytes from decoding the block, if it was decoded.
+*/
+func (d *Data) annotations() []payload.Annotation {
+	if d.Decoding == nil {
+		return nil
+	}
+	return d.Decoding.Annotations
+}
+
+/*
+findPosition works out where in the image this block lives. Strips and tiles are stored row by row and, when the
+planar configuration is 2 (planar), each sample plane is stored one after another.
+*/
+func (d *Data) findPosition() error {
+	imageLength, err := d.IFD.FieldValue(257)
+	if err != nil {
+		return err
+	}
+
+	var across, down uint64
+	if d.IsTile {
+		imageWidth, err := d.IFD.FieldValue(256)
+		if err != nil {
+			return err
+		}
+		tileWidth, err := d.IFD.FieldValue(322)
+		if err != nil {
+			return err
+		}
+		tileLength, err := d.IFD.FieldValue(323)
+		if err != nil {
+			return err
+		}
+		if tileWidth == 0 || tileLength == 0 {
+			return fmt.Errorf("tile size of %v by %v is invalid", tileWidth, tileLength)
+		}
+		across = divideRoundUp(imageWidth, tileWidth)
+		down = divideRoundUp(imageLength, tileLength)
+	} else {
+		rowsPerStrip, err := d.IFD.FieldValue(278)
+		if err != nil || rowsPerStrip == 0 {
+			// the default is a single strip for the whole image
+			rowsPerStrip = imageLength
+		}
+		if rowsPerStrip == 0 {
+			return fmt.Errorf("image length is 0")
+		}
+		across = 1
+		down = divideRoundUp(imageLength, rowsPerStrip)
+	}
+
+	perPlane := across * down
+	if perPlane == 0 {
+		return fmt.Errorf("image does not contain any blocks")
+	}
+
+	i := uint64(d.I)
+	if planar, err := d.IFD.FieldValue(284); err == nil && planar == 2 {
+		d.Plane = i / perPlane
+		i = i % perPlane
+	}
+	d.X = i % across
+	d.Y = i / across
 
 	return nil
 }
 
+/*
+divideRoundUp divides a by b rounding up, giving 0 when b is 0 rather than panicking.
+*/
+func divideRoundUp(a uint64, b uint64) uint64 {
+	if b == 0 {
+		return 0
+	}
+	result := a / b
+	if a%b != 0 {
+		result++
+	}
+	return result
+}
+
+/*
+OffsetsFieldId is the field that holds the position of this block, StripOffsets or TileOffsets.
+*/
+func (d *Data) OffsetsFieldId() uint16 {
+	if d.IsTile {
+		return 324 // TileOffsets
+	}
+	return 273 // StripOffsets
+}
+
+/*
+ByteCountsFieldId is the field that holds the size of this block, StripByteCounts or TileByteCounts.
+*/
+func (d *Data) ByteCountsFieldId() uint16 {
+	if d.IsTile {
+		return 325 // TileByteCounts
+	}
+	return 279 // StripByteCounts
+}
+
+/*
+Range returns the start of the block of image data and one past its end.
+*/
+func (d *Data) Range() (int64, int64) {
+	return d.Start, d.End
+}
+
 func (d *Data) Contains(offset int64) bool {
 	return d.Start <= offset && offset < d.End
 }
@@ -53,60 +222,82 @@ func (d *Data) Split(start int64, end int64, newBit parser.Region) error {
 	return fmt.Errorf("data can not be split")
 }
 
-func (d *Data) Render() ([]payload.Section, error) {
+func (d *Data) render() ([]payload.Section, error) {
+	desc, err := payload.RenderTemplate(dataTemplate, d, template.FuncMap{
+		"FieldNames": func(fieldId uint16) string {
+			return fieldName(d.IFD, fieldId)
+		},
+		"Decoding": d.describeDecoding,
+		"FieldLink": func(fieldId uint16) int64 {
+			field, err := d.IFD.FindField(fieldId)
+			if err != nil {
+				return d.IFD.Start
+			}
+			return field.linkTarget()
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not render data description, %v", err)
+	}
+
 	return []payload.Section{
 		&payload.General{
 			Start:   d.Start,
 			End:     d.End - 1,
 			Id:      "data",
-			TheData: template.HTML("data hidden for size"),
-			Text:    template.HTML("A block of image data"),
+			TheData: renderPreview(d.Start, d.End, d.Head, d.Tail, false, d.annotations()),
+			Text:    template.HTML(desc),
 		},
 	}, nil
 }
 
-func (d *Data) fetchFieldValue(id uint16, in io.ReadSeeker, order binary.ByteOrder) (int64, error) {
+const dataTemplate = `A block of image data, {{ if not .Located }}{{ if .IsTile }}a tile{{ else }}a strip{{ end }} at an unknown position in the image 
+{{ else if .IsTile }}tile ({{ .X }},{{ .Y }}) of plane {{ .Plane }} {{ else }}strip {{ .Y }} of plane {{ .Plane }} {{ end }}
+from the <a href="#{{ .IFD.Start }}">IFD at {{ .IFD.Start }}</a>. 
+Its position is entry {{ .I }} of <a href="#{{ FieldLink .OffsetsFieldId }}">{{ FieldNames .OffsetsFieldId }}</a> 
+and its size is entry {{ .I }} of <a href="#{{ FieldLink .ByteCountsFieldId }}">{{ FieldNames .ByteCountsFieldId }}</a>
+{{ if .Missing }}<br /><span class="past_end">Only part of this block is in the file, the last {{ .Missing }} bytes are past the end of the file</span>{{ end }}
+{{ Decoding }}`
+
+func (d *Data) fetchFieldValue(id uint16) (int64, error) {
 	field, err := d.IFD.FindField(id)
 	if err != nil {
 		return 0, fmt.Errorf("could not find field %v, %v", id, err)
 	}
 
-	if field.IsOffset {
-		// now we need to go and find the lookup value for d.I
-		// work out where we need to jump to
-
-		pos := int64(field.Value) + int64(constants.DataTypeSize[field.DType] * uint32(d.I))
-
-		_, err := in.Seek(pos, io.SeekStart)
-		if err != nil {
-			return 0, fmt.Errorf("could not seek to find offset value, %v", err)
-		}
-
-		buf := make([]byte, constants.DataTypeSize[field.DType])
-		n, err := in.Read(buf)
-		if err != nil {
-			return 0, fmt.Errorf("could not read offset value, %v", err)
-		}
-		if n != int(constants.DataTypeSize[field.DType]) {
-			return 0, fmt.Errorf("reading offset got the wrong number of bytes, expected %v got %v",constants.DataTypeSize[field.DType], n)
-		}
-		return int64(ReadBuffer(buf, order)), nil
-
+	values := field.DecodedValues()
 
+	if d.I >= len(values.Unsigned) {
+		return 0, fmt.Errorf("field %v does not have an unsigned entry %v, it has %v values", id, d.I, values.Len())
 	}
-
-	return int64(field.Value), nil
+	return int64(values.Unsigned[d.I]), nil
 }
 
-
-func ReadBuffer(buf []byte, order binary.ByteOrder) uint32 {
+func ReadBuffer(buf []byte, order binary.ByteOrder) uint64 {
 	len := len(buf)
 
+	if len == 1 {
+		return uint64(buf[0])
+	}
 	if len == 2 {
-		return uint32(order.Uint16(buf))
+		return uint64(order.Uint16(buf))
 	}
 	if len == 4 {
-		return order.Uint32(buf)
+		return uint64(order.Uint32(buf))
+	}
+	if len == 8 {
+		return order.Uint64(buf)
 	}
 	return 0
-}
\ No newline at end of file
+}
+
+/*
+referrer is the field holding the position of this block, used to link to it when the block can not be placed.
+*/
+func (d *Data) referrer() Node {
+	field, err := d.IFD.FindField(d.OffsetsFieldId())
+	if err != nil {
+		return d.IFD
+	}
+	return field
+}
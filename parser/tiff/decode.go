@@ -0,0 +1,391 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/emilyselwood/tiffhax/parser/tiff/constants"
+	"github.com/emilyselwood/tiffhax/payload"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+)
+
+/*
+Decoding is what was found when decompressing a data block. Only a summary is kept, the decoded data itself is counted
+and thrown away apart from the first few bytes.
+*/
+type Decoding struct {
+	Compression uint64
+	Method      string
+	// Expected is how many bytes the block should decode to, worked out from the size of the image. It is -1 when that
+	// could not be worked out.
+	Expected int64
+	// Decoded is how many bytes the block decoded to.
+	Decoded int64
+	// Preview holds the first bytes of the decoded data.
+	Preview []byte
+	// Predictor is the name of the predictor used before compressing, empty when there is none.
+	Predictor string
+	// Samples are the first bytes of the decoded data with the predictor undone, empty when it could not be undone.
+	Samples []byte
+	// Values are the first few samples read as numbers.
+	Values []string
+	// Annotations mark up the compressed bytes, relative to the start of the block. Only those that can be seen in the
+	// preview of the block are kept.
+	Annotations []payload.Annotation
+	// Notes are facts found while decoding that are worth showing.
+	Notes []string
+	// Problems are things that are wrong with the compressed data, each is also added as a diagnostic.
+	Problems []string
+	// Stopped is set when decoding was stopped at the limit before the end of the data.
+	Stopped bool
+	// SegmentsOnly is set when the block is only split into its parts rather than decoded, as with JPEG, so there is
+	// no decoded size to check.
+	SegmentsOnly bool
+}
+
+// how many bytes of decoded data are kept to show.
+const decodedPreviewSize = 64
+
+/*
+decoder decompresses the bytes of a block, writing what it decodes to the decodeState and annotating the compressed
+bytes as it goes. It should stop when write returns false.
+*/
+type decoder func(data []byte, state *decodeState)
+
+// decoders are the compression schemes that can be decoded, by the value of the Compression field.
+var decoders = map[uint64]decoder{
+	5:     decodeLZW,
+	7:     decodeJPEG,
+	8:     decodeDeflate,
+	32773: decodePackBits,
+	32946: decodeDeflate,
+}
+
+/*
+decodeState collects the results of a decoder.
+*/
+type decodeState struct {
+	result *Decoding
+	// the limit on how much is decoded
+	limit int64
+	// the size of the compressed data, to know which annotations can be seen in the preview
+	size int64
+	// how many decoded bytes to keep, when more than the preview are needed to undo a predictor
+	keep int64
+	// the JPEGTables of the image and where they are, nil when it has none
+	jpegTables      *JPEGStream
+	jpegTablesStart int64
+	// set when the decoder has already reported decoding to more than expected
+	overrun bool
+}
+
+/*
+write adds decoded bytes, returning false when the limit has been reached and decoding should stop.
+*/
+func (s *decodeState) write(b ...byte) bool {
+	if s.result.Stopped {
+		return false
+	}
+	keep := s.keep
+	if keep < decodedPreviewSize {
+		keep = decodedPreviewSize
+	}
+	if missing := keep - int64(len(s.result.Preview)); missing > 0 {
+		if missing > int64(len(b)) {
+			missing = int64(len(b))
+		}
+		s.result.Preview = append(s.result.Preview, b[:missing]...)
+	}
+	s.result.Decoded += int64(len(b))
+	if s.limit > 0 && s.result.Decoded >= s.limit {
+		s.result.Stopped = true
+		return false
+	}
+	return true
+}
+
+/*
+Write lets decoders from the standard library write to the state.
+*/
+func (s *decodeState) Write(b []byte) (int, error) {
+	if !s.write(b...) {
+		return len(b), errDecodeLimit
+	}
+	return len(b), nil
+}
+
+var errDecodeLimit = fmt.Errorf("decode limit reached")
+
+/*
+annotate marks the compressed bytes from start to end, keeping it only if it is in the part of the block that is shown.
+Codes that are not a whole number of bytes can share a byte, which goes to the first of them.
+*/
+func (s *decodeState) annotate(start int64, end int64, class string, format string, args ...interface{}) {
+	if start >= dataPreviewSize && end <= s.size-dataPreviewSize {
+		return
+	}
+	if n := len(s.result.Annotations); n > 0 && start < s.result.Annotations[n-1].End {
+		start = s.result.Annotations[n-1].End
+	}
+	if start >= end {
+		return
+	}
+	s.result.Annotations = append(s.result.Annotations, payload.Annotation{
+		Start: start,
+		End:   end,
+		Class: class,
+		Title: fmt.Sprintf(format, args...),
+	})
+}
+
+func (s *decodeState) note(format string, args ...interface{}) {
+	s.result.Notes = append(s.result.Notes, fmt.Sprintf(format, args...))
+}
+
+func (s *decodeState) problem(format string, args ...interface{}) {
+	s.result.Problems = append(s.result.Problems, fmt.Sprintf(format, args...))
+}
+
+/*
+decodeCache remembers the blocks that have been decoded, so a block shared by several strips or IFDs is only decoded
+once, and keeps count of how much of the total decode limit has been used.
+*/
+type decodeCache struct {
+	decoded map[decodeKey]*Decoding
+	used    int64
+}
+
+func newDecodeCache() *decodeCache {
+	return &decodeCache{decoded: map[decodeKey]*Decoding{}}
+}
+
+/*
+decodeKey is everything that changes how a block decodes, the bytes it covers and how the image says to decode them.
+*/
+type decodeKey struct {
+	start       int64
+	end         int64
+	compression uint64
+	expected    int64
+	layout      predictorLayout
+	jpegTables  *JPEGStream
+}
+
+/*
+decode decompresses the block if we know how to, checking it decodes to the size the image says it should and undoing
+any predictor on the start of it. Blocks bigger than the limit are not read, and once the total limit has been used
+up no more blocks are decoded. A block that has already been decoded shares the decoding from before.
+*/
+func (d *Data) decode(in io.ReadSeeker, order binary.ByteOrder, limits Limits, cache *decodeCache) error {
+	compression := uint64(1)
+	if value, err := d.IFD.FieldValue(259); err == nil {
+		compression = value
+	}
+	decode, ok := decoders[compression]
+	if !ok || d.End <= d.Start {
+		return nil
+	}
+
+	result := Decoding{
+		Compression: compression,
+		Method:      constants.FieldValueLookup[259][uint32(compression)],
+		Expected:    -1,
+	}
+	if expected, ok := d.expectedSize(); ok {
+		result.Expected = expected
+	}
+	layout, predicted := d.predictorLayout()
+	var jpegTables *JPEGStream
+	var jpegTablesStart int64
+	if field, err := d.IFD.FindField(347); err == nil && field.Offset != nil && field.Offset.JPEG != nil {
+		jpegTables = field.Offset.JPEG
+		jpegTablesStart = field.Offset.Start
+	}
+
+	key := decodeKey{start: d.Start, end: d.End, compression: compression, expected: result.Expected, layout: layout, jpegTables: jpegTables}
+	if shared, ok := cache.decoded[key]; ok {
+		d.Decoding = shared
+		return nil
+	}
+	cache.decoded[key] = &result
+	d.Decoding = &result
+
+	size := d.End - d.Start
+	if limits.MaxDecodeBytes > 0 && size > limits.MaxDecodeBytes {
+		result.Stopped = true
+		result.Notes = append(result.Notes, fmt.Sprintf("the block is bigger than the decode limit of %v bytes so was not decoded", limits.MaxDecodeBytes))
+		return nil
+	}
+	limit := limits.MaxDecodeBytes
+	if limits.MaxTotalDecodeBytes > 0 {
+		left := limits.MaxTotalDecodeBytes - cache.used - size
+		if left <= 0 {
+			result.Stopped = true
+			result.Notes = append(result.Notes, fmt.Sprintf("the total decode limit of %v bytes has been used up so the block was not decoded", limits.MaxTotalDecodeBytes))
+			return nil
+		}
+		if limit <= 0 || left < limit {
+			limit = left
+		}
+	}
+	data, err := readAt(in, d.Start, size)
+	if err != nil {
+		return fmt.Errorf("could not read block to decode, %v", err)
+	}
+
+	state := decodeState{result: &result, limit: limit, size: size, keep: layout.keep(), jpegTables: jpegTables, jpegTablesStart: jpegTablesStart}
+	decode(data, &state)
+	cache.used += size + result.Decoded
+	if predicted {
+		layout.undo(&state, order)
+	}
+	if len(result.Preview) > decodedPreviewSize {
+		result.Preview = result.Preview[:decodedPreviewSize]
+	}
+
+	if !result.Stopped && !result.SegmentsOnly && result.Expected >= 0 {
+		if result.Decoded > result.Expected {
+			if !state.overrun {
+				state.problem("decodes to %v bytes, %v more than the %v expected", result.Decoded, result.Decoded-result.Expected, result.Expected)
+			}
+		} else if result.Decoded < result.Expected {
+			state.problem("decodes to %v bytes, %v fewer than the %v expected", result.Decoded, result.Expected-result.Decoded, result.Expected)
+		}
+	}
+	return nil
+}
+
+/*
+expectedSize works out how many bytes the block should hold once decompressed from the size of the image.
+*/
+func (d *Data) expectedSize() (int64, bool) {
+	width, rows, ok := d.pixelSize()
+	if !ok {
+		return 0, false
+	}
+
+	samples := uint64(1)
+	if value, err := d.IFD.FieldValue(277); err == nil {
+		samples = value
+	}
+	var bits []uint64
+	if field, err := d.IFD.FindField(258); err == nil {
+		bits = field.DecodedValues().Unsigned
+	}
+	if len(bits) == 0 {
+		bits = []uint64{1}
+	}
+
+	// bits for each pixel of this block, planar blocks only hold one sample
+	var pixelBits uint64
+	if planar, err := d.IFD.FieldValue(284); err == nil && planar == 2 {
+		pixelBits = bits[0]
+		if d.Plane < uint64(len(bits)) {
+			pixelBits = bits[d.Plane]
+		}
+	} else if uint64(len(bits)) == samples {
+		for _, b := range bits {
+			pixelBits += b
+		}
+	} else {
+		pixelBits = multiplySaturating(bits[0], samples)
+	}
+
+	rowBytes := divideRoundUp(multiplySaturating(width, pixelBits), 8)
+	size := multiplySaturating(rowBytes, rows)
+	if size > math.MaxInt64/2 {
+		return 0, false
+	}
+	return int64(size), true
+}
+
+/*
+pixelSize is the width and height of the block in pixels. The last strip of an image can be shorter than the others.
+*/
+func (d *Data) pixelSize() (uint64, uint64, bool) {
+	if d.IsTile {
+		tileWidth, err := d.IFD.FieldValue(322)
+		if err != nil {
+			return 0, 0, false
+		}
+		tileLength, err := d.IFD.FieldValue(323)
+		if err != nil {
+			return 0, 0, false
+		}
+		return tileWidth, tileLength, true
+	}
+
+	if !d.Located {
+		return 0, 0, false
+	}
+	width, err := d.IFD.FieldValue(256)
+	if err != nil {
+		return 0, 0, false
+	}
+	imageLength, err := d.IFD.FieldValue(257)
+	if err != nil {
+		return 0, 0, false
+	}
+	rowsPerStrip, err := d.IFD.FieldValue(278)
+	if err != nil || rowsPerStrip == 0 || rowsPerStrip > imageLength {
+		rowsPerStrip = imageLength
+	}
+	rows := rowsPerStrip
+	if start := multiplySaturating(d.Y, rowsPerStrip); start >= imageLength {
+		return 0, 0, false
+	} else if imageLength-start < rows {
+		rows = imageLength - start
+	}
+	return width, rows, true
+}
+
+/*
+describeDecoding says what was found when decoding the block for its description.
+*/
+func (d *Data) describeDecoding() template.HTML {
+	result := d.Decoding
+	if result == nil {
+		return ""
+	}
+
+	var desc strings.Builder
+	_, _ = fmt.Fprintf(&desc, "<br />Compressed with %v", template.HTMLEscapeString(result.Method))
+	switch {
+	case result.SegmentsOnly && result.Stopped:
+		desc.WriteString(", not split into its parts")
+	case result.SegmentsOnly:
+		desc.WriteString(", split into its parts, the image data itself is not decoded")
+	case result.Stopped && result.Decoded == 0:
+		desc.WriteString(", not decoded")
+	case result.Stopped:
+		_, _ = fmt.Fprintf(&desc, ", decoding stopped at the limit after %v bytes", result.Decoded)
+	default:
+		_, _ = fmt.Fprintf(&desc, ", decodes to %v bytes", result.Decoded)
+	}
+	if result.Expected >= 0 && !result.SegmentsOnly {
+		_, _ = fmt.Fprintf(&desc, " of the %v expected", result.Expected)
+	}
+	for _, note := range result.Notes {
+		desc.WriteString("<br />")
+		desc.WriteString(template.HTMLEscapeString(note))
+	}
+	for _, problem := range result.Problems {
+		desc.WriteString("<br /><span class=\"decode_problem\">")
+		desc.WriteString(template.HTMLEscapeString(problem))
+		desc.WriteString("</span>")
+	}
+	if len(result.Preview) > 0 {
+		desc.WriteString("<br />Decoded data starts with ")
+		desc.WriteString(payload.RenderBytes(result.Preview))
+	}
+	if len(result.Samples) > 0 {
+		_, _ = fmt.Fprintf(&desc, "<br />With the %v predictor undone the samples start with ", template.HTMLEscapeString(strings.ToLower(result.Predictor)))
+		desc.WriteString(payload.RenderBytes(result.Samples))
+	}
+	if len(result.Values) > 0 {
+		_, _ = fmt.Fprintf(&desc, "<br />The first samples are %v", strings.Join(result.Values, ", "))
+	}
+	return template.HTML(desc.String())
+}
@@ -0,0 +1,572 @@
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+/*
+decodeTest runs a decoder over some compressed bytes expecting them to decode to expected bytes.
+*/
+func decodeTest(decode decoder, data []byte, expected int64) *Decoding {
+	result := Decoding{Expected: expected}
+	state := decodeState{result: &result, size: int64(len(data))}
+	decode(data, &state)
+	return &result
+}
+
+func TestDecodePackBits(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		decoded  []byte
+		expected int64
+		problems []string
+		classes  []string
+	}{
+		{
+			name:     "literal and repeat",
+			data:     []byte{2, 'a', 'b', 'c', 0xfd, 'x', 0x80, 0, 'z'},
+			decoded:  []byte("abcxxxxz"),
+			expected: 8,
+			classes:  []string{"packbits_literal_header", "packbits_literal", "packbits_run", "packbits_noop", "packbits_literal_header", "packbits_literal"},
+		},
+		{
+			name:     "literal past the end",
+			data:     []byte{5, 'a', 'b'},
+			decoded:  []byte("ab"),
+			expected: 2,
+			problems: []string{"literal run of 6 bytes at byte 0 that runs 4 bytes past the end"},
+		},
+		{
+			name:     "repeat missing its byte",
+			data:     []byte{0, 'a', 0xff},
+			decoded:  []byte("a"),
+			expected: 1,
+			problems: []string{"repeat run at byte 2 that is missing the byte to repeat"},
+		},
+		{
+			name:     "run past the end of the image",
+			data:     []byte{0xf9, 'x', 0xf9, 'y'},
+			decoded:  []byte("xxxxxxxxyyyyyyyy"),
+			expected: 12,
+			problems: []string{"run at byte 2 that goes 4 bytes past the end of the image data"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := decodeTest(decodePackBits, test.data, test.expected)
+			if !bytes.Equal(result.Preview, test.decoded) || result.Decoded != int64(len(test.decoded)) {
+				t.Errorf("decoded %v bytes starting %q expected %q", result.Decoded, result.Preview, test.decoded)
+			}
+			if len(result.Problems) != len(test.problems) {
+				t.Fatalf("got problems %v expected %v", result.Problems, test.problems)
+			}
+			for i, problem := range test.problems {
+				if !strings.Contains(result.Problems[i], problem) {
+					t.Errorf("problem was %q expected %q", result.Problems[i], problem)
+				}
+			}
+			if test.classes != nil {
+				var classes []string
+				for _, a := range result.Annotations {
+					classes = append(classes, a.Class)
+				}
+				if strings.Join(classes, " ") != strings.Join(test.classes, " ") {
+					t.Errorf("annotations were %v expected %v", classes, test.classes)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDecodesStrips(t *testing.T) {
+	b := newTestBuilder(binary.LittleEndian, false)
+	// three strips of 2 rows of 4 pixels, the second decodes to one byte too few and the third to three too many
+	first := b.add([]byte{0xf9, 7})
+	second := b.add([]byte{0xfa, 9})
+	third := b.add([]byte{0xf6, 5})
+	b.setFirstIFD(b.ifd([]testEntry{
+		b.short(256, 4),
+		b.short(257, 6),
+		b.short(258, 8),
+		b.short(259, 32773),
+		b.long(273, uint32(first), uint32(second), uint32(third)),
+		b.short(278, 2),
+		b.short(279, 2, 2, 2),
+	}, 0))
+
+	doc := parseTest(t, b)
+	if len(doc.Data) != 3 {
+		t.Fatalf("got %v strips expected 3", len(doc.Data))
+	}
+	for i, d := range doc.Data {
+		if d.Decoding == nil || d.Decoding.Method != "PackBits" || d.Decoding.Expected != 8 {
+			t.Fatalf("strip %v was not decoded as expected: %+v", i, d.Decoding)
+		}
+	}
+	if doc.Data[0].Decoding.Decoded != 8 || len(doc.Data[0].Decoding.Problems) != 0 {
+		t.Errorf("first strip decoded to %v with problems %v", doc.Data[0].Decoding.Decoded, doc.Data[0].Decoding.Problems)
+	}
+	// the run that goes past the end is reported rather than the size of the whole strip
+	expected := []string{
+		"decodes to 7 bytes, 1 fewer than the 8 expected",
+		"has a run at byte 0 that goes 3 bytes past the end of the image data",
+	}
+	if len(doc.Diagnostics) != len(expected) {
+		t.Fatalf("expected the short and long strips to be reported once each, got %v", doc.Diagnostics)
+	}
+	for i, message := range expected {
+		if !strings.Contains(doc.Diagnostics[i].Message, message) {
+			t.Errorf("diagnostic was %q expected %q", doc.Diagnostics[i].Message, message)
+		}
+	}
+	if _, err := RenderHTML(doc); err != nil {
+		t.Errorf("could not render: %v", err)
+	}
+}
+
+func TestParseSharedDecoding(t *testing.T) {
+	b := newTestBuilder(binary.LittleEndian, false)
+	// the first three strips share a block that decodes to far more than a strip should
+	shared := zlibCompress(make([]byte, 1024), nil)
+	last := zlibCompress(make([]byte, 8), nil)
+	sharedAt := b.add(shared)
+	lastAt := b.add(last)
+	b.setFirstIFD(b.ifd([]testEntry{
+		b.short(256, 4),
+		b.short(257, 8),
+		b.short(258, 8),
+		b.short(259, 8),
+		b.long(273, uint32(sharedAt), uint32(sharedAt), uint32(sharedAt), uint32(lastAt)),
+		b.short(278, 2),
+		b.long(279, uint32(len(shared)), uint32(len(shared)), uint32(len(shared)), uint32(len(last))),
+	}, 0))
+
+	limits := DefaultLimits
+	// enough for the shared block but not the last one
+	limits.MaxTotalDecodeBytes = int64(len(shared) + 1024 + len(last))
+	doc, err := ParseWithLimits(b.reader(), limits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(doc.Data) != 4 {
+		t.Fatalf("got %v strips expected 4", len(doc.Data))
+	}
+	for _, d := range doc.Data[1:3] {
+		if d.Decoding != doc.Data[0].Decoding {
+			t.Errorf("strip %v was decoded again", d.I)
+		}
+	}
+	if decoding := doc.Data[0].Decoding; decoding == nil || decoding.Decoded != 1024 {
+		t.Fatalf("the shared block was not decoded: %+v", decoding)
+	}
+	if len(doc.Diagnostics) != 1 || !strings.Contains(doc.Diagnostics[0].Message, "decodes to 1024 bytes, 1016 more than the 8 expected") {
+		t.Errorf("expected the shared block to be reported once, got %v", doc.Diagnostics)
+	}
+	if decoding := doc.Data[3].Decoding; decoding == nil || !decoding.Stopped || decoding.Decoded != 0 ||
+		len(decoding.Notes) != 1 || !strings.Contains(decoding.Notes[0], "total decode limit") {
+		t.Errorf("the last strip should not be decoded once the total limit is used up: %+v", decoding)
+	}
+	if _, err := RenderHTML(doc); err != nil {
+		t.Errorf("could not render: %v", err)
+	}
+}
+
+/*
+lzwPack packs LZW codes most significant bit first, growing the width when a decoder would.
+*/
+func lzwPack(codes []int) []byte {
+	var out []byte
+	var acc uint64
+	var bits uint
+	width := 9
+	next := lzwFirst
+	first := true
+	for _, code := range codes {
+		acc = acc<<uint(width) | uint64(code)
+		bits += uint(width)
+		for bits >= 8 {
+			out = append(out, byte(acc>>(bits-8)))
+			bits -= 8
+		}
+		switch {
+		case code == lzwClear:
+			width, next, first = 9, lzwFirst, true
+		case first:
+			first = false
+		default:
+			next++
+			if next+1 == 1<<uint(width) && width < 12 {
+				width++
+			}
+		}
+	}
+	if bits > 0 {
+		out = append(out, byte(acc<<(8-bits)))
+	}
+	return out
+}
+
+/*
+lzwCodes compresses data into LZW codes, starting with a clear code and ending with an end of information code.
+*/
+func lzwCodes(data []byte) []int {
+	codes := []int{lzwClear}
+	dictionary := map[string]int{}
+	next := lzwFirst
+	code := func(w string) int {
+		if len(w) == 1 {
+			return int(w[0])
+		}
+		return dictionary[w]
+	}
+	w := ""
+	for _, c := range data {
+		wc := w + string([]byte{c})
+		if _, ok := dictionary[wc]; ok || len(wc) == 1 {
+			w = wc
+			continue
+		}
+		codes = append(codes, code(w))
+		dictionary[wc] = next
+		next++
+		w = string([]byte{c})
+	}
+	if w != "" {
+		codes = append(codes, code(w))
+	}
+	return append(codes, lzwEOI)
+}
+
+func TestDecodeLZW(t *testing.T) {
+	var data []byte
+	for i := 0; i < 1200; i++ {
+		data = append(data, byte(i*i/7), byte(i%13))
+	}
+	full := lzwCodes(data)
+
+	tests := []struct {
+		name     string
+		codes    []int
+		raw      []byte
+		decoded  []byte
+		problems []string
+		notes    []string
+	}{
+		{
+			name:    "round trip",
+			codes:   full,
+			decoded: data,
+			notes:   []string{"1 clear codes at bytes 0, the code width grew 3 times, to 10 bits at byte 287, 11 bits at byte 927, 12 bits at byte 2335"},
+		},
+		{
+			name:    "kwkwk",
+			codes:   []int{lzwClear, 'a', lzwFirst, lzwEOI},
+			decoded: []byte("aaa"),
+		},
+		{
+			name:     "missing end of information",
+			codes:    full[:len(full)-1],
+			decoded:  data,
+			problems: []string{"has no end of information code"},
+		},
+		{
+			name:     "invalid code",
+			codes:    []int{lzwClear, 'a', 'b', 300, lzwEOI},
+			decoded:  []byte("ab"),
+			problems: []string{"invalid code 300 at byte 3 when the table only has 259 entries"},
+		},
+		{
+			name:     "no clear code",
+			codes:    []int{'a', 'b', lzwEOI},
+			decoded:  []byte("ab"),
+			problems: []string{"does not start with a clear code"},
+		},
+		{
+			name:     "old style",
+			raw:      []byte{0, 1, 2, 3},
+			problems: []string{"old style of LZW"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw := test.raw
+			if raw == nil {
+				raw = lzwPack(test.codes)
+			}
+			result := decodeTest(decodeLZW, raw, int64(len(test.decoded)))
+			preview := test.decoded
+			if len(preview) > decodedPreviewSize {
+				preview = preview[:decodedPreviewSize]
+			}
+			if result.Decoded != int64(len(test.decoded)) || !bytes.Equal(result.Preview, preview) {
+				t.Errorf("decoded %v bytes starting %v expected %v starting %v", result.Decoded, result.Preview, len(test.decoded), preview)
+			}
+			if len(result.Problems) != len(test.problems) {
+				t.Fatalf("got problems %v expected %v", result.Problems, test.problems)
+			}
+			for i, problem := range test.problems {
+				if !strings.Contains(result.Problems[i], problem) {
+					t.Errorf("problem was %q expected %q", result.Problems[i], problem)
+				}
+			}
+			for _, note := range test.notes {
+				if !strings.Contains(strings.Join(result.Notes, "\n"), note) {
+					t.Errorf("notes %v should contain %q", result.Notes, note)
+				}
+			}
+		})
+	}
+}
+
+func zlibCompress(data []byte, dictionary []byte) []byte {
+	var out bytes.Buffer
+	w, _ := zlib.NewWriterLevelDict(&out, zlib.BestCompression, dictionary)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return out.Bytes()
+}
+
+func TestDecodeDeflate(t *testing.T) {
+	data := bytes.Repeat([]byte("tiffhax deflate "), 20)
+	good := zlibCompress(data, nil)
+	badChecksum := append([]byte{}, good...)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+
+	tests := []struct {
+		name     string
+		data     []byte
+		decoded  int64
+		problems []string
+		notes    []string
+		classes  []string
+	}{
+		{
+			name:    "good",
+			data:    good,
+			decoded: int64(len(data)),
+			notes:   []string{"32768 byte window and the maximum compression level", "Adler-32 checksum", "matches"},
+			classes: []string{"zlib_cmf", "zlib_flg", "zlib_adler"},
+		},
+		{
+			name:     "bad checksum",
+			data:     badChecksum,
+			decoded:  int64(len(data)),
+			problems: []string{"but the decoded data has a checksum of"},
+			classes:  []string{"zlib_cmf", "zlib_flg", "zlib_adler"},
+		},
+		{
+			name:     "missing checksum",
+			data:     good[:len(good)-4],
+			decoded:  int64(len(data)),
+			problems: []string{"is missing the Adler-32 checksum"},
+			classes:  []string{"zlib_cmf", "zlib_flg"},
+		},
+		{
+			name:     "truncated",
+			data:     good[:len(good)/2],
+			problems: []string{"ends before the last block of deflate data"},
+			classes:  []string{"zlib_cmf", "zlib_flg"},
+		},
+		{
+			name:    "trailing bytes",
+			data:    append(append([]byte{}, good...), 0, 0, 0),
+			decoded: int64(len(data)),
+			notes:   []string{"3 bytes after the end of the zlib stream"},
+			classes: []string{"zlib_cmf", "zlib_flg", "zlib_adler"},
+		},
+		{
+			name:     "preset dictionary",
+			data:     zlibCompress(data, []byte("tiffhax")),
+			problems: []string{"needs a preset dictionary"},
+			classes:  []string{"zlib_cmf", "zlib_flg", "zlib_dictionary"},
+		},
+		{
+			name:     "bad header check",
+			data:     []byte{0x78, 0x9b, 0, 0},
+			problems: []string{"fails its check"},
+			classes:  []string{"zlib_cmf", "zlib_flg"},
+		},
+		{
+			name:     "not deflate",
+			data:     []byte{0x7f, 0x01},
+			problems: []string{"compression method of 15"},
+			classes:  []string{"zlib_cmf", "zlib_flg"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := decodeTest(decodeDeflate, test.data, -1)
+			if test.decoded > 0 && result.Decoded != test.decoded {
+				t.Errorf("decoded %v bytes expected %v", result.Decoded, test.decoded)
+			}
+			if len(result.Problems) != len(test.problems) {
+				t.Fatalf("got problems %v expected %v", result.Problems, test.problems)
+			}
+			for i, problem := range test.problems {
+				if !strings.Contains(result.Problems[i], problem) {
+					t.Errorf("problem was %q expected %q", result.Problems[i], problem)
+				}
+			}
+			for _, note := range test.notes {
+				if !strings.Contains(strings.Join(result.Notes, "\n"), note) {
+					t.Errorf("notes %v should contain %q", result.Notes, note)
+				}
+			}
+			var classes []string
+			for _, a := range result.Annotations {
+				classes = append(classes, a.Class)
+			}
+			if strings.Join(classes, " ") != strings.Join(test.classes, " ") {
+				t.Errorf("annotated %v expected %v", classes, test.classes)
+			}
+		})
+	}
+}
+
+/*
+predictTest applies a predictor to rows of samples the way a writer would before compressing them.
+*/
+func predictTest(predictor int, data []byte, rowBytes int, stride int, size int, order binary.ByteOrder) []byte {
+	result := append([]byte{}, data...)
+	for row := 0; row < len(result); row += rowBytes {
+		r := result[row : row+rowBytes]
+		if predictor == predictorHorizontal {
+			for i := len(r) - size; i >= stride*size; i -= size {
+				writeSample(r[i:], size, order, readSample(r[i:], size, order)-readSample(r[i-stride*size:], size, order))
+			}
+			continue
+		}
+		count := len(r) / size
+		shuffled := make([]byte, len(r))
+		for i := 0; i < count; i++ {
+			for b := 0; b < size; b++ {
+				if order == binary.BigEndian {
+					shuffled[b*count+i] = r[i*size+b]
+				} else {
+					shuffled[b*count+i] = r[i*size+size-1-b]
+				}
+			}
+		}
+		for i := len(shuffled) - 1; i >= stride; i-- {
+			shuffled[i] -= shuffled[i-stride]
+		}
+		copy(r, shuffled)
+	}
+	return result
+}
+
+func TestParsePredictor(t *testing.T) {
+	floats := make([]byte, 40)
+	for i := 0; i < 10; i++ {
+		binary.LittleEndian.PutUint32(floats[i*4:], math.Float32bits(float32(i)*1.5-3))
+	}
+	shorts := make([]byte, 48)
+	for i := 0; i < 24; i++ {
+		binary.BigEndian.PutUint16(shorts[i*2:], uint16(1000+i*i*37))
+	}
+
+	tests := []struct {
+		name      string
+		order     binary.ByteOrder
+		predictor int
+		samples   []byte
+		width     uint16
+		stride    uint16
+		bits      uint16
+		format    uint16
+		values    string
+		problem   string
+	}{
+		{
+			name:      "horizontal differencing",
+			order:     binary.BigEndian,
+			predictor: predictorHorizontal,
+			samples:   shorts,
+			width:     4,
+			stride:    3,
+			bits:      16,
+			format:    1,
+			values:    "1000, 1037, 1148, 1333, 1592, 1925, 2332, 2813",
+		},
+		{
+			name:      "floating point",
+			order:     binary.LittleEndian,
+			predictor: predictorFloatingPoint,
+			samples:   floats,
+			width:     5,
+			stride:    1,
+			bits:      32,
+			format:    3,
+			values:    "-3, -1.5, 0, 1.5, 3, 4.5, 6, 7.5",
+		},
+		{
+			name:      "horizontal differencing of 4 bit samples",
+			order:     binary.LittleEndian,
+			predictor: predictorHorizontal,
+			samples:   []byte{0x12, 0x34, 0x56, 0x78},
+			width:     4,
+			stride:    1,
+			bits:      4,
+			format:    1,
+			problem:   "uses horizontal differencing with 4 bit samples",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rowBytes := int(test.width*test.stride*test.bits) / 8
+			rows := len(test.samples) / rowBytes
+			predicted := test.samples
+			if test.problem == "" {
+				predicted = predictTest(test.predictor, test.samples, rowBytes, int(test.stride), int(test.bits/8), test.order)
+			}
+
+			b := newTestBuilder(test.order, false)
+			strip := b.add(zlibCompress(predicted, nil))
+			b.setFirstIFD(b.ifd([]testEntry{
+				b.short(256, test.width),
+				b.short(257, uint16(rows)),
+				b.short(258, test.bits),
+				b.short(259, 8),
+				b.long(273, uint32(strip)),
+				b.short(277, test.stride),
+				b.short(279, uint16(int64(len(b.bytes()))-strip)),
+				b.short(317, uint16(test.predictor)),
+				b.short(339, test.format),
+			}, 0))
+
+			doc := parseTest(t, b)
+			if len(doc.Data) != 1 || doc.Data[0].Decoding == nil {
+				t.Fatalf("strip was not decoded")
+			}
+			result := doc.Data[0].Decoding
+			if test.problem != "" {
+				if len(result.Problems) != 1 || !strings.Contains(result.Problems[0], test.problem) {
+					t.Errorf("got problems %v expected %q", result.Problems, test.problem)
+				}
+				return
+			}
+			if len(result.Problems) != 0 {
+				t.Errorf("got problems %v", result.Problems)
+			}
+			if !bytes.Equal(result.Samples, test.samples) {
+				t.Errorf("samples were %v expected %v", result.Samples, test.samples)
+			}
+			if values := strings.Join(result.Values, ", "); values != test.values {
+				t.Errorf("values were %v expected %v", values, test.values)
+			}
+			if _, err := RenderHTML(doc); err != nil {
+				t.Errorf("could not render: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package tiff
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/adler32"
+	"io"
+)
+
+/*
+decodeDeflate decodes zlib wrapped deflate data (compression 8 and 32946). The two byte zlib header gives the
+compression method and window size and says if a preset dictionary is needed, the deflate data follows and the stream
+ends with an Adler-32 checksum of the decoded data.
+*/
+func decodeDeflate(data []byte, s *decodeState) {
+	if len(data) < 2 {
+		s.problem("is too short to hold a zlib header")
+		return
+	}
+	cmf, flg := data[0], data[1]
+	method, info := cmf&0x0f, cmf>>4
+	level := flg >> 6
+	dictionary := flg&0x20 != 0
+
+	s.annotate(0, 1, "zlib_cmf", "compression method %v, %v byte window", method, 1<<(uint(info)+8))
+	s.annotate(1, 2, "zlib_flg", "compression level %v (%v), preset dictionary %v", level, zlibLevels[level], dictionary)
+	if method != 8 {
+		s.problem("has a zlib compression method of %v, only 8 (deflate) is defined", method)
+		return
+	}
+	if info > 7 {
+		s.problem("has a zlib window size of %v bytes, more than the largest of 32768", 1<<(uint(info)+8))
+		return
+	}
+	if (uint16(cmf)<<8|uint16(flg))%31 != 0 {
+		s.problem("has a zlib header that fails its check, this may not be zlib data")
+		return
+	}
+	s.note("zlib header with a %v byte window and the %v compression level", 1<<(uint(info)+8), zlibLevels[level])
+
+	start := int64(2)
+	if dictionary {
+		if len(data) < 6 {
+			s.problem("is too short to hold the id of its preset dictionary")
+			return
+		}
+		s.annotate(2, 6, "zlib_dictionary", "preset dictionary %08X", binary.BigEndian.Uint32(data[2:6]))
+		s.problem("needs a preset dictionary with an Adler-32 checksum of %08X, which TIFF has no way to give, so can not be decoded", binary.BigEndian.Uint32(data[2:6]))
+		return
+	}
+
+	// a bytes.Reader is read one byte at a time by flate, so what is left after the deflate stream is the trailer
+	remaining := bytes.NewReader(data[start:])
+	checksum := adler32.New()
+	_, err := io.Copy(io.MultiWriter(s, checksum), flate.NewReader(remaining))
+	var corrupt flate.CorruptInputError
+	switch {
+	case err == errDecodeLimit:
+		return
+	case errors.As(err, &corrupt):
+		s.problem("has corrupt deflate data at byte %v", start+int64(corrupt))
+		return
+	case err == io.ErrUnexpectedEOF:
+		s.problem("ends before the last block of deflate data")
+		return
+	case err != nil:
+		s.problem("could not be inflated, %v", err)
+		return
+	}
+
+	end := int64(len(data) - remaining.Len())
+	if deflated := end - start; deflated > 0 {
+		s.note("%v bytes of deflate data decode to %v bytes, a ratio of %.2f", deflated, s.result.Decoded, float64(s.result.Decoded)/float64(deflated))
+	}
+	if remaining.Len() < 4 {
+		s.problem("is missing the Adler-32 checksum at the end of the zlib stream")
+		return
+	}
+	stored := binary.BigEndian.Uint32(data[end : end+4])
+	s.annotate(end, end+4, "zlib_adler", "Adler-32 checksum %08X", stored)
+	if actual := checksum.Sum32(); stored != actual {
+		s.problem("has an Adler-32 checksum of %08X but the decoded data has a checksum of %08X", stored, actual)
+	} else {
+		s.note("Adler-32 checksum %08X matches the decoded data", stored)
+	}
+	if after := int64(len(data)) - end - 4; after > 0 {
+		s.note("%v bytes after the end of the zlib stream", after)
+	}
+}
+
+// the names zlib gives the compression levels in the header.
+var zlibLevels = [4]string{"fastest", "fast", "default", "maximum"}
@@ -0,0 +1,58 @@
+package tiff
+
+import (
+	"fmt"
+)
+
+/*
+Severity says how bad a diagnostic is. Errors mean part of the file could not be read, warnings are for things that
+look wrong but did not stop parsing.
+*/
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+/*
+Diagnostic is a problem found while parsing. Offset is the position in the file the problem is about and Node is the
+part of the document that led to it, such as the field holding a bad offset. Node is nil when nothing led to it.
+*/
+type Diagnostic struct {
+	Severity Severity
+	Offset   int64
+	Message  string
+	Node     Node
+}
+
+/*
+Link is the position of the row that best shows the problem, the start of the node if there is one.
+*/
+func (d Diagnostic) Link() int64 {
+	if d.Node == nil {
+		return d.Offset
+	}
+	start, _ := d.Node.Range()
+	return start
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%v at %v: %v", d.Severity, d.Offset, d.Message)
+}
+
+func (doc *Document) addDiagnostic(severity Severity, offset int64, node Node, format string, args ...interface{}) {
+	doc.Diagnostics = append(doc.Diagnostics, Diagnostic{
+		Severity: severity,
+		Offset:   offset,
+		Message:  fmt.Sprintf(format, args...),
+		Node:     node,
+	})
+}
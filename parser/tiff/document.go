@@ -0,0 +1,111 @@
+package tiff
+
+import (
+	"github.com/emilyselwood/tiffhax/parser"
+	"sort"
+	"strings"
+)
+
+/*
+Document holds everything found while parsing a tiff file. When parsing fails part way through it holds everything
+found up to that point.
+
+The parts of the file are linked together, fields know their IFD, offsets know the field that points at them, sub IFDs
+know their parent and data blocks know the IFD that describes them. All byte ranges are half open, Start is the first
+byte and End is one past the last.
+*/
+type Document struct {
+	Root    *parser.Unknown
+	Header  *Header
+	IFDs    []*IFD
+	Offsets []*Offset
+	Data    []*Data
+	// Gaps describes the parts of the file that nothing claimed, by the unknown region that covers them.
+	Gaps map[*parser.Unknown]*Gap
+	// Diagnostics lists the problems found while parsing, in the order they were found.
+	Diagnostics []Diagnostic
+	// Limits are the caps the document was parsed with.
+	Limits Limits
+
+	sections sectionCount
+}
+
+/*
+Node is a part of a document with a byte range. The concrete types are *Header, *IFD, *Offset, *Data and
+*parser.Unknown for the parts of the file that were not claimed by anything. Fields are reached through their IFD,
+although a diagnostic can point at a *Field.
+*/
+type Node interface {
+	Range() (int64, int64)
+}
+
+/*
+Nodes lists the top level parts of the document in file order, including the gaps between them. Parts that partly
+overlap others come straight after the part their first byte is in.
+*/
+func (doc *Document) Nodes() []Node {
+	overlapping := append([]parser.Region{}, doc.Root.Overlapping...)
+	sort.SliceStable(overlapping, func(i, j int) bool {
+		a, _ := overlapping[i].Range()
+		b, _ := overlapping[j].Range()
+		return a < b
+	})
+
+	var result []Node
+	for _, r := range doc.Root.Leaves() {
+		result = append(result, r)
+		_, end := r.Range()
+		for len(overlapping) > 0 {
+			if start, _ := overlapping[0].Range(); start >= end {
+				break
+			}
+			result = append(result, overlapping[0])
+			overlapping = overlapping[1:]
+		}
+	}
+	return result
+}
+
+/*
+TopLevelIFDs returns the main chain of IFDs, those that are not sub IFDs of another.
+*/
+func (doc *Document) TopLevelIFDs() []*IFD {
+	var result []*IFD
+	for _, ifd := range doc.IFDs {
+		if ifd.Parent == nil {
+			result = append(result, ifd)
+		}
+	}
+	return result
+}
+
+/*
+Name is the name of the field, looked up in the dictionary for the kind of IFD it is in.
+*/
+func (f *Field) Name() string {
+	return fieldName(f.IFD, f.ID)
+}
+
+/*
+DecodedValues returns the values of the field, following the offset if they did not fit in the field.
+*/
+func (f *Field) DecodedValues() Values {
+	if f.IsOffset {
+		if f.Offset == nil {
+			return Values{DType: f.DType}
+		}
+		return f.Offset.Values
+	}
+	return f.Values
+}
+
+/*
+Text returns the value of an ascii field with the trailing nulls removed. It is false for any other type of field.
+*/
+func (f *Field) Text() (string, bool) {
+	if f.DType != 2 {
+		return "", false
+	}
+	data, _ := f.valueBytes()
+	return strings.TrimRight(string(data), "\x00"), true
+}
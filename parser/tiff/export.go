@@ -0,0 +1,288 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/emilyselwood/tiffhax/parser/tiff/constants"
+	"io"
+	"math"
+)
+
+/*
+Export is a machine readable description of a tiff file, meant to be turned into json. All byte ranges are half open,
+Start is the first byte and End is one past the last.
+*/
+type Export struct {
+	FileName    string             `json:"file_name,omitempty"`
+	Header      *ExportHeader      `json:"header,omitempty"`
+	IFDs        []ExportIFD        `json:"ifds"`
+	Offsets     []ExportOffset     `json:"offsets"`
+	Data        []ExportData       `json:"data"`
+	Unparsed    []ExportGap        `json:"unparsed"`
+	Diagnostics []ExportDiagnostic `json:"diagnostics"`
+	Error       string             `json:"error,omitempty"`
+}
+
+type ExportRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type ExportGap struct {
+	ExportRange
+	Kind       string `json:"kind"`
+	NotScanned bool   `json:"not_scanned,omitempty"`
+}
+
+type ExportDiagnostic struct {
+	Severity string `json:"severity"`
+	Offset   int64  `json:"offset"`
+	Link     int64  `json:"link"`
+	Message  string `json:"message"`
+}
+
+type ExportHeader struct {
+	ExportRange
+	ByteOrder      string `json:"byte_order"`
+	BigTiff        bool   `json:"big_tiff"`
+	OffsetSize     uint16 `json:"offset_size"`
+	FirstIFDOffset int64  `json:"first_ifd_offset"`
+}
+
+type ExportIFD struct {
+	ExportRange
+	Name        string        `json:"name"`
+	Kind        string        `json:"kind"`
+	Parent      string        `json:"parent,omitempty"`
+	ParentField uint16        `json:"parent_field,omitempty"`
+	FieldCount  uint64        `json:"field_count"`
+	Fields      []ExportField `json:"fields"`
+	NotLoaded   uint64        `json:"fields_not_loaded,omitempty"`
+	Next        uint64        `json:"next"`
+}
+
+type ExportField struct {
+	ExportRange
+	ID       uint16      `json:"id"`
+	Name     string      `json:"name"`
+	Type     uint16      `json:"type"`
+	TypeName string      `json:"type_name"`
+	Count    uint64      `json:"count"`
+	IsOffset bool        `json:"is_offset"`
+	Offset   *int64      `json:"offset,omitempty"`
+	PastEnd  uint64      `json:"past_end,omitempty"`
+	Values   interface{} `json:"values"`
+}
+
+type ExportOffset struct {
+	ExportRange
+	IFD       string `json:"ifd"`
+	FieldID   uint16 `json:"field_id"`
+	Field     string `json:"field"`
+	From      int64  `json:"from"`
+	NotLoaded int64  `json:"not_loaded,omitempty"`
+}
+
+type ExportData struct {
+	ExportRange
+	IFD      string          `json:"ifd"`
+	Index    int             `json:"index"`
+	IsTile   bool            `json:"is_tile"`
+	X        uint64          `json:"x"`
+	Y        uint64          `json:"y"`
+	Plane    uint64          `json:"plane"`
+	Missing  uint64          `json:"missing,omitempty"`
+	Decoding *ExportDecoding `json:"decoding,omitempty"`
+}
+
+type ExportDecoding struct {
+	Method    string   `json:"method"`
+	Expected  int64    `json:"expected"`
+	Decoded   int64    `json:"decoded"`
+	Stopped   bool     `json:"stopped,omitempty"`
+	Predictor string   `json:"predictor,omitempty"`
+	Values    []string `json:"values,omitempty"`
+	Notes     []string `json:"notes,omitempty"`
+	Problems  []string `json:"problems,omitempty"`
+}
+
+/*
+ExportFile parses a tiff file and builds the machine readable description of it. If parsing fails part way through
+the export holds everything found so far along with the error.
+*/
+func ExportFile(in io.ReadSeeker) (*Export, error) {
+	doc, err := Parse(in)
+	if doc == nil {
+		return nil, err
+	}
+
+	result := doc.Export()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, err
+}
+
+/*
+Export builds the machine readable description of a parsed file.
+*/
+func (doc *Document) Export() *Export {
+	result := Export{
+		IFDs:        []ExportIFD{},
+		Offsets:     []ExportOffset{},
+		Data:        []ExportData{},
+		Unparsed:    []ExportGap{},
+		Diagnostics: []ExportDiagnostic{},
+	}
+
+	if doc.Header != nil {
+		order := "II"
+		if doc.Header.Endian == binary.BigEndian {
+			order = "MM"
+		}
+		result.Header = &ExportHeader{
+			ExportRange:    ExportRange{Start: doc.Header.Start, End: doc.Header.End},
+			ByteOrder:      order,
+			BigTiff:        doc.Header.BigTiff,
+			OffsetSize:     doc.Header.OffsetSize,
+			FirstIFDOffset: doc.Header.FirstIFDOffset,
+		}
+	}
+
+	for _, ifd := range doc.IFDs {
+		result.IFDs = append(result.IFDs, exportIFD(ifd))
+	}
+
+	for _, o := range doc.Offsets {
+		result.Offsets = append(result.Offsets, ExportOffset{
+			ExportRange: ExportRange{Start: o.Start, End: o.End},
+			IFD:         o.IFD.Name(),
+			FieldID:     o.FieldId,
+			Field:       o.Field.Name(),
+			From:        o.From,
+			NotLoaded:   o.NotLoaded,
+		})
+	}
+
+	for _, d := range doc.Data {
+		result.Data = append(result.Data, ExportData{
+			ExportRange: ExportRange{Start: d.Start, End: d.End},
+			IFD:         d.IFD.Name(),
+			Index:       d.I,
+			IsTile:      d.IsTile,
+			X:           d.X,
+			Y:           d.Y,
+			Plane:       d.Plane,
+			Missing:     d.Missing,
+			Decoding:    exportDecoding(d.Decoding),
+		})
+	}
+
+	for _, gap := range doc.Root.Gaps() {
+		kind := UnknownGap
+		unscanned := false
+		if g, ok := doc.Gaps[gap]; ok {
+			kind = g.Kind
+			unscanned = g.Unscanned
+		}
+		result.Unparsed = append(result.Unparsed, ExportGap{ExportRange: ExportRange{Start: gap.Start, End: gap.End}, Kind: kind.String(), NotScanned: unscanned})
+	}
+
+	for _, d := range doc.Diagnostics {
+		result.Diagnostics = append(result.Diagnostics, ExportDiagnostic{
+			Severity: d.Severity.String(),
+			Offset:   d.Offset,
+			Link:     d.Link(),
+			Message:  d.Message,
+		})
+	}
+
+	return &result
+}
+
+func exportDecoding(d *Decoding) *ExportDecoding {
+	if d == nil {
+		return nil
+	}
+	return &ExportDecoding{
+		Method:    d.Method,
+		Expected:  d.Expected,
+		Decoded:   d.Decoded,
+		Stopped:   d.Stopped,
+		Predictor: d.Predictor,
+		Values:    d.Values,
+		Notes:     d.Notes,
+		Problems:  d.Problems,
+	}
+}
+
+func exportIFD(ifd *IFD) ExportIFD {
+	result := ExportIFD{
+		ExportRange: ExportRange{Start: ifd.Start, End: ifd.End},
+		Name:        ifd.Name(),
+		Kind:        ifd.Kind.String(),
+		FieldCount:  ifd.Count,
+		Fields:      []ExportField{},
+		NotLoaded:   ifd.NotLoaded,
+		Next:        ifd.Next,
+	}
+	if ifd.Parent != nil {
+		result.Parent = ifd.Parent.Name()
+		result.ParentField = ifd.ParentField.ID
+	}
+
+	for _, f := range ifd.Children {
+		field := ExportField{
+			ExportRange: ExportRange{Start: f.Start, End: f.End},
+			ID:          f.ID,
+			Name:        f.Name(),
+			Type:        f.DType,
+			TypeName:    constants.DataTypeNames[f.DType],
+			Count:       f.Count,
+			IsOffset:    f.IsOffset,
+			PastEnd:     f.PastEnd,
+		}
+		if f.IsOffset {
+			offset := int64(f.Value)
+			field.Offset = &offset
+		}
+		if text, ok := f.Text(); ok {
+			field.Values = text
+		} else {
+			field.Values = exportValues(f.DecodedValues())
+		}
+		result.Fields = append(result.Fields, field)
+	}
+
+	return result
+}
+
+/*
+exportValues turns decoded values into something json can hold. Rationals become [numerator, denominator] pairs and
+floats that json can not represent become strings.
+*/
+func exportValues(v Values) interface{} {
+	switch {
+	case v.Unsigned != nil:
+		return v.Unsigned
+	case v.Signed != nil:
+		return v.Signed
+	case v.Floats != nil:
+		result := make([]interface{}, 0, len(v.Floats))
+		for _, f := range v.Floats {
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				result = append(result, fmt.Sprint(f))
+			} else {
+				result = append(result, f)
+			}
+		}
+		return result
+	case v.Rationals != nil:
+		result := make([][2]int64, 0, len(v.Rationals))
+		for _, r := range v.Rationals {
+			result = append(result, [2]int64{r.Numerator, r.Denominator})
+		}
+		return result
+	}
+	return []interface{}{}
+}
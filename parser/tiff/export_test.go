@@ -0,0 +1,77 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportJSON(t *testing.T) {
+	b := stripTIFF(binary.LittleEndian, false)
+	// point the description past the end of the file so there is a diagnostic
+	b.setFieldValue(b.ifds[0], 5, uint64(len(b.bytes())-4))
+
+	export, err := ExportFile(b.reader())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encoded, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("could not marshal: %v", err)
+	}
+	// decode without the export types so the names used in the json are checked
+	var result map[string]interface{}
+	if err := json.Unmarshal(encoded, &result); err != nil {
+		t.Fatalf("could not unmarshal: %v", err)
+	}
+
+	header := result["header"].(map[string]interface{})
+	if header["byte_order"] != "II" || header["big_tiff"] != false || header["first_ifd_offset"] != float64(b.ifds[0]) {
+		t.Errorf("header was %v", header)
+	}
+
+	ifds := result["ifds"].([]interface{})
+	if len(ifds) != 1 {
+		t.Fatalf("got %v ifds expected 1", len(ifds))
+	}
+	ifd := ifds[0].(map[string]interface{})
+	if ifd["name"] != "IFD 0" || ifd["start"] != float64(b.ifds[0]) || ifd["field_count"] != float64(10) || ifd["next"] != float64(0) {
+		t.Errorf("ifd was %v", ifd)
+	}
+	fields := ifd["fields"].([]interface{})
+	if len(fields) != 10 {
+		t.Fatalf("got %v fields expected 10", len(fields))
+	}
+
+	tests := []struct {
+		index    int
+		expected map[string]interface{}
+	}{
+		{0, map[string]interface{}{"id": float64(256), "name": "ImageWidth", "type_name": "short", "count": float64(1), "is_offset": false, "values": []interface{}{float64(4)}}},
+		{5, map[string]interface{}{"id": float64(270), "name": "ImageDescription", "type_name": "ascii", "count": float64(12), "is_offset": true, "offset": float64(len(b.bytes()) - 4), "past_end": float64(8)}},
+		{9, map[string]interface{}{"id": float64(282), "name": "XResolution", "type": float64(5), "values": []interface{}{[]interface{}{float64(72), float64(1)}}}},
+	}
+	for _, test := range tests {
+		field := fields[test.index].(map[string]interface{})
+		for key, value := range test.expected {
+			if !reflect.DeepEqual(field[key], value) {
+				t.Errorf("field %v has %v of %v expected %v", test.index, key, field[key], value)
+			}
+		}
+	}
+
+	diagnostics := result["diagnostics"].([]interface{})
+	if len(diagnostics) != 1 {
+		t.Fatalf("got %v diagnostics expected 1", len(diagnostics))
+	}
+	diagnostic := diagnostics[0].(map[string]interface{})
+	if diagnostic["severity"] != "error" || diagnostic["offset"] != float64(len(b.bytes())-4) ||
+		!strings.Contains(diagnostic["message"].(string), "the values of ImageDescription point past the end of the file by 8 bytes") {
+		t.Errorf("diagnostic was %v", diagnostic)
+	}
+	if diagnostic["link"] != float64(b.fieldStart(b.ifds[0], 5)) {
+		t.Errorf("diagnostic should link to the description field, got %v", diagnostic["link"])
+	}
+}
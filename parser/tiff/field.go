@@ -2,51 +2,68 @@ package tiff
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"github.com/emilyselwood/tiffhax/parser"
 	"github.com/emilyselwood/tiffhax/parser/tiff/constants"
 	"github.com/emilyselwood/tiffhax/payload"
 	"html/template"
 	"io"
+	"strings"
 )
 
 type Field struct {
+	parser.Sharing
+
 	Start    int64
 	End      int64
 	Data     []byte
 	ID       uint16
 	DType    uint16
-	Count    uint32
-	Value    uint32
+	Count    uint64
+	Value    uint64
 	IsOffset bool
+	IFD      *IFD
+	// Offset is where the values are when they do not fit in the field.
+	Offset   *Offset
+	// Values holds the decoded values when they fit in the field.
+	Values   Values
+	// PastEnd is how many bytes what this field points to runs past the end of the file.
+	PastEnd  uint64
 }
 
-func ParseField(in io.Reader, start int64, order binary.ByteOrder) (*Field, *Offset, *Data, error) {
-	data := make([]byte, 12)
+func ParseField(in io.Reader, start int64, header *Header) (*Field, *Offset, []*Data, error) {
+	order := header.Endian
+	size := header.fieldSize()
+	data := make([]byte, size)
 	n, err := in.Read(data)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("could not read ifd field, %v", err)
 	}
-	if n != 12 {
-		return nil, nil, nil, fmt.Errorf("strange size read from ifd field got %v expected 12", n)
+	if int64(n) != size {
+		return nil, nil, nil, fmt.Errorf("strange size read from ifd field got %v expected %v", n, size)
 	}
 
 	var result Field
 	result.Start = start
-	result.End = start + 12
+	result.End = start + size
 	result.Data = data
 
 	// parse the actual data.
 	result.ID = order.Uint16(data[0:2])
 	result.DType = order.Uint16(data[2:4])
-	result.Count = order.Uint32(data[4:8])
-	result.Value = order.Uint32(data[8:12])
+	if header.BigTiff {
+		result.Count = order.Uint64(data[4:12])
+	} else {
+		result.Count = uint64(order.Uint32(data[4:8]))
+	}
+	valueSlot := data[size-int64(header.OffsetSize):]
+	typeSize := constants.DataTypeSize[result.DType]
 	// TODO: parse ascii better if its not an offset.
 
 	// do we have an offset or a value
-	if result.Count*constants.DataTypeSize[result.DType] > 4 {
+	if multiplySaturating(result.Count, uint64(typeSize)) > uint64(header.OffsetSize) {
 		result.IsOffset = true
+		result.Value = header.readOffset(valueSlot)
 
 		var offset Offset
 		offset.DType = result.DType
@@ -54,24 +71,45 @@ func ParseField(in io.Reader, start int64, order binary.ByteOrder) (*Field, *Off
 		offset.To = int64(result.Value)
 		offset.Count = result.Count
 		offset.FieldId = result.ID
+		offset.Field = &result
 
 		if result.ID == 273 || result.ID == 324 {
 			offset.IsData = true
 		}
+		result.Offset = &offset
 
 		return &result, &offset, nil, nil
 	}
 
-	if result.ID == 273 || result.ID == 324 { // stripOffset field wasn't an offset so it must be a single pointer.
-		var d Data
-		d.Start = int64(result.Value)
+	// inline values are left justified in the slot so only read as many bytes as the type needs.
+	if typeSize == 0 || int(typeSize) > len(valueSlot) {
+		// an unknown type, or a count of zero for a type bigger than the slot
+		result.Value = header.readOffset(valueSlot)
+	} else {
+		result.Value = ReadBuffer(valueSlot[:typeSize], order)
+	}
+	inline, _ := result.valueBytes()
+	result.Values = DecodeValues(inline, result.DType, order)
+
+	if result.ID == 273 || result.ID == 324 { // stripOffset field wasn't an offset so the pointers are in the field.
+		var data []*Data
+		for i, v := range result.Values.Unsigned {
+			data = append(data, &Data{Start: int64(v), I: i, IsTile: result.ID == 324})
+		}
 
-		return &result, nil, &d, nil
+		return &result, nil, data, nil
 	}
 
 	return &result, nil, nil, nil
 }
 
+/*
+Range returns the start of the field within its IFD and one past its end.
+*/
+func (f *Field) Range() (int64, int64) {
+	return f.Start, f.End
+}
+
 func (f *Field) Contains(offset int64) bool {
 	return f.Start <= offset && offset < f.End
 }
@@ -91,33 +129,51 @@ func (f *Field) Split(start int64, end int64, newBit parser.Region) error {
 	return fmt.Errorf("field can not be split")
 }
 
-func (f *Field) Render() ([]payload.Section, error) {
+func (f *Field) render() ([]payload.Section, error) {
 
 	desc, err := payload.RenderTemplate(fieldTemplate, f, template.FuncMap{
 		"FieldNames": func(fieldId uint16) string {
-			return constants.FieldNames[fieldId]
+			return fieldName(f.IFD, fieldId)
 		},
 		"DataTypeNames": func(typeId uint16) string {
 			return constants.DataTypeNames[typeId]
 		},
-		"FieldValueLookUp" : func() string {
+		"FieldValueLookUp" : func() template.HTML {
 			if f.IsOffset {
 				return " which is an offset"
 			}
+			if kind, ok := f.subIFDKind(); ok {
+				var links []string
+				for _, offset := range f.subIFDOffsets() {
+					links = append(links, fmt.Sprintf("<a href=\"#%v\">%v at %v</a>", offset, kind.describe(), offset))
+				}
+				return template.HTML(" which points to the " + strings.Join(links, ", "))
+			}
 
 			fieldValues, ok := constants.FieldValueLookup[f.ID]
 			if ok {
-				value, ok := fieldValues[f.Value]
+				value, ok := fieldValues[uint32(f.Value)]
 				if ok {
-					return " which means " + value
+					return template.HTML(" which means " + template.HTMLEscapeString(value))
 				}
 			}
-			if f.DType == 2 {
-				value := string(f.Data)
-				return " which decodes to " + value
+			if value, ok := f.Text(); ok {
+				return template.HTML(" which decodes to " + template.HTMLEscapeString(value))
+			}
+			if f.Values.Len() > 1 || f.Values.Signed != nil || f.Values.Floats != nil || f.Values.Rationals != nil {
+				return " which decodes to " + renderValues(f.Values)
 			}
 			return ""
 		},
+		"PastEnd": func() template.HTML {
+			if f.PastEnd == 0 {
+				return ""
+			}
+			if f.ID == 273 || f.ID == 324 {
+				return template.HTML(fmt.Sprintf(" <span class=\"past_end\">and some of the image data it points to runs past the end of the file by up to %v bytes</span>", f.PastEnd))
+			}
+			return template.HTML(fmt.Sprintf(" <span class=\"past_end\">which points past the end of the file by %v bytes</span>", f.PastEnd))
+		},
 		// TODO: better descriptions
 	})
 	if err != nil {
@@ -130,9 +186,14 @@ func (f *Field) Render() ([]payload.Section, error) {
 	data.WriteRune(' ')
 	payload.RenderBytesSpan(&data, f.Data[2:4], "field_type")
 	data.WriteRune(' ')
-	payload.RenderBytesSpan(&data, f.Data[4:8], "field_count")
+	payload.RenderBytesSpan(&data, f.Data[4:len(f.Data)-len(f.valueSlot())], "field_count")
 	data.WriteRune(' ')
-	payload.RenderBytesSpan(&data, f.Data[8:12], "field_value")
+	if isRational(f.DType) && !f.IsOffset {
+		// a big tiff can hold a single rational in the field
+		payload.RenderByteBlocks(&data, f.valueSlot(), 4, rationalClasses)
+	} else {
+		payload.RenderBytesSpan(&data, f.valueSlot(), "field_value")
+	}
 
 	return []payload.Section{
 		&payload.General{
@@ -146,7 +207,83 @@ func (f *Field) Render() ([]payload.Section, error) {
 
 }
 
-const fieldTemplate = `A field called <span class="field_id">{{ FieldNames .ID}}</span> 
+/*
+markPastEnd records how far past the end of the file something this field points to goes, keeping the largest.
+*/
+func (f *Field) markPastEnd(over uint64) {
+	if over > f.PastEnd {
+		f.PastEnd = over
+	}
+}
+
+/*
+subIFDKind says if this field points at an EXIF, GPS or Interoperability IFD and what kind of IFD that is.
+*/
+func (f *Field) subIFDKind() (IFDKind, bool) {
+	kind, ok := subIFDPointers[f.ID]
+	if !ok || f.IsOffset || len(f.subIFDOffsets()) == 0 {
+		return kind, false
+	}
+	if f.IFD != nil && (f.IFD.Kind == GPSIFD || f.IFD.Kind == InteropIFD) {
+		// these directories have their own meanings for field ids.
+		return kind, false
+	}
+	return kind, true
+}
+
+/*
+subIFDOffsets returns the non zero values held in the field, which for a sub IFD pointer are the offsets of the IFDs.
+A big tiff can fit two SubIFDs offsets in the field.
+*/
+func (f *Field) subIFDOffsets() []int64 {
+	var result []int64
+	for _, v := range f.Values.Unsigned {
+		if v != 0 {
+			result = append(result, int64(v))
+		}
+	}
+	return result
+}
+
+/*
+linkTarget is where a link to the values of this field should point. That is the offset for values stored elsewhere or
+the field itself when the value is held in the IFD.
+*/
+func (f *Field) linkTarget() int64 {
+	if f.IsOffset {
+		return int64(f.Value)
+	}
+	return f.Start
+}
+
+/*
+valueBytes returns the raw bytes of the values of this field and where in the file they start, following the offset if
+there is one.
+*/
+func (f *Field) valueBytes() ([]byte, int64) {
+	if f.IsOffset {
+		if f.Offset == nil {
+			return nil, int64(f.Value)
+		}
+		return f.Offset.Data, f.Offset.Start
+	}
+	slot := f.valueSlot()
+	size := multiplySaturating(f.Count, uint64(constants.DataTypeSize[f.DType]))
+	if size > uint64(len(slot)) {
+		size = uint64(len(slot))
+	}
+	return slot[:size], f.End - int64(len(slot))
+}
+
+/*
+valueSlot returns the bytes holding the value or offset of the field. The count and value slots are both 4 bytes in a
+normal tiff and 8 bytes in a big tiff.
+*/
+func (f *Field) valueSlot() []byte {
+	return f.Data[4+(len(f.Data)-4)/2:]
+}
+
+const fieldTemplate = `A field {{ if .IFD }}{{ if .IFD.Kind }}in the <span class="ifd_kind">{{ .IFD.Kind }}</span> IFD {{ end }}{{ end }}called <span class="field_id">{{ FieldNames .ID}}</span> 
 is <span class="field_count">{{ .Count }}</span> <span class="field_type">{{ DataTypeNames .DType }}</span> values. 
-The value shows {{ if .IsOffset }}<a href="#{{ .Value }}">{{end}}<span class="field_value">{{ .Value }}</span>{{ if .IsOffset }}<a/>{{end}}
-{{ FieldValueLookUp }}`
+The value shows {{ if .IsOffset }}<a href="#{{ .Value }}">{{end}}<span class="field_value">{{ .Value }}</span>{{ if .IsOffset }}</a>{{end}}
+{{ FieldValueLookUp }}{{ PastEnd }}`
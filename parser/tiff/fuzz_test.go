@@ -0,0 +1,200 @@
+//go:build go1.18
+// +build go1.18
+
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+// how long a single input can take before it is treated as an infinite loop
+const fuzzTimeout = 10 * time.Second
+
+// how much can be allocated for each byte of input, on top of a fixed allowance for the templates and buffers.
+const (
+	fuzzAllocationBase    = 32 << 20
+	fuzzAllocationPerByte = 8 << 10
+)
+
+/*
+checkBounded runs fn failing the test if it returns an error, panics, takes too long or allocates far more memory than
+the size of the input would explain. It runs in its own goroutine so that an infinite loop can be reported.
+*/
+func checkBounded(t *testing.T, size int, fn func() error) {
+	t.Helper()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	done := make(chan string, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Sprintf("panic: %v\n%s", p, debug.Stack())
+			}
+		}()
+		if err := fn(); err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- ""
+	}()
+
+	select {
+	case problem := <-done:
+		if problem != "" {
+			t.Fatal(problem)
+		}
+	case <-time.After(fuzzTimeout):
+		t.Fatalf("did not finish within %v", fuzzTimeout)
+	}
+
+	runtime.ReadMemStats(&after)
+	limit := uint64(fuzzAllocationBase + fuzzAllocationPerByte*size)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > limit {
+		t.Fatalf("allocated %v bytes for %v bytes of input, the limit is %v", allocated, size, limit)
+	}
+}
+
+func fuzzSeeds() [][]byte {
+	seeds := [][]byte{
+		stripTIFF(binary.LittleEndian, false).bytes(),
+		stripTIFF(binary.BigEndian, false).bytes(),
+		stripTIFF(binary.LittleEndian, true).bytes(),
+		stripTIFF(binary.BigEndian, true).bytes(),
+		tiledTIFF(binary.LittleEndian).bytes(),
+		tiledTIFF(binary.BigEndian).bytes(),
+	}
+	for _, data := range malformedTIFFs() {
+		seeds = append(seeds, data)
+	}
+	return seeds
+}
+
+func FuzzParseFile(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkBounded(t, len(data), func() error {
+			_, _ = ParseFile(bytes.NewReader(data))
+			_, _ = ExportFile(bytes.NewReader(data))
+			return nil
+		})
+	})
+}
+
+func FuzzParseHeader(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed[:16])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkBounded(t, len(data), func() error {
+			header, l, err := ParseHeader(bytes.NewReader(data))
+			if err != nil {
+				return nil
+			}
+			if l != header.End || l > int64(len(data)) {
+				return fmt.Errorf("header claims %v bytes, ends at %v with %v bytes of input", l, header.End, len(data))
+			}
+			if _, err := header.render(); err != nil {
+				return fmt.Errorf("could not render header: %v", err)
+			}
+			return nil
+		})
+	})
+}
+
+/*
+fuzzHeader makes a header to read IFDs and fields with, as they need to know the byte order and offset size.
+*/
+func fuzzHeader(big bool, bigEndian bool) *Header {
+	header := Header{Endian: binary.LittleEndian, BigTiff: big, OffsetSize: 4}
+	if bigEndian {
+		header.Endian = binary.BigEndian
+	}
+	if big {
+		header.OffsetSize = 8
+	}
+	return &header
+}
+
+func FuzzParseIFD(f *testing.F) {
+	for _, big := range []bool{false, true} {
+		for _, bigEndian := range []bool{false, true} {
+			order := binary.ByteOrder(binary.LittleEndian)
+			if bigEndian {
+				order = binary.BigEndian
+			}
+			b := stripTIFF(order, big)
+			f.Add(b.bytes()[b.ifds[0]:], big, bigEndian)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte, big bool, bigEndian bool) {
+		checkBounded(t, len(data), func() error {
+			ifd, _, _, _, err := ParseIFD(bytes.NewReader(data), 0, fuzzHeader(big, bigEndian))
+			if err != nil {
+				return nil
+			}
+			if ifd.End > int64(len(data)) {
+				return fmt.Errorf("ifd ends at %v with %v bytes of input", ifd.End, len(data))
+			}
+			if _, err := ifd.render(); err != nil {
+				return fmt.Errorf("could not render ifd: %v", err)
+			}
+			return nil
+		})
+	})
+}
+
+func FuzzParseField(f *testing.F) {
+	for _, big := range []bool{false, true} {
+		b := stripTIFF(binary.LittleEndian, big)
+		for i := 0; i < 10; i++ {
+			start := b.fieldStart(b.ifds[0], i)
+			f.Add(b.bytes()[start:b.fieldStart(b.ifds[0], i+1)], big, false)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte, big bool, bigEndian bool) {
+		checkBounded(t, len(data), func() error {
+			field, _, _, err := ParseField(bytes.NewReader(data), 0, fuzzHeader(big, bigEndian))
+			if err != nil {
+				return nil
+			}
+			if _, err := field.render(); err != nil {
+				return fmt.Errorf("could not render field: %v", err)
+			}
+			return nil
+		})
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{0x02, 'a', 'b', 'c', 0xfe, 'd', 0x80}, uint16(32773))
+	f.Add(lzwPack(lzwCodes([]byte("TOBEORNOTTOBEORTOBEORNOT"))), uint16(5))
+	f.Add(zlibCompress([]byte("TOBEORNOTTOBEORTOBEORNOT"), nil), uint16(8))
+	f.Add([]byte{0xff, 0xd8, 0xff, 0xdb, 0, 67, 0}, uint16(7))
+	f.Add([]byte{0xff, 0xd8, 0xff, 0xda, 0, 8, 1, 1, 0, 0, 63, 0, 1, 2, 0xff, 0, 0xff, 0xd0, 3, 0xff, 0xd9}, uint16(7))
+	f.Fuzz(func(t *testing.T, data []byte, compression uint16) {
+		decode, ok := decoders[uint64(compression)]
+		if !ok {
+			return
+		}
+		checkBounded(t, len(data), func() error {
+			result := decodeTest(decode, data, -1)
+			var end int64
+			for _, a := range result.Annotations {
+				if a.Start < end || a.End <= a.Start || a.End > int64(len(data)) {
+					return fmt.Errorf("annotation %v to %v out of order or outside %v bytes", a.Start, a.End, len(data))
+				}
+				end = a.End
+			}
+			return nil
+		})
+	})
+}
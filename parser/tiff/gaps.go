@@ -0,0 +1,208 @@
+package tiff
+
+import (
+	"fmt"
+	"github.com/emilyselwood/tiffhax/parser"
+	"github.com/emilyselwood/tiffhax/payload"
+	"html/template"
+	"io"
+)
+
+/*
+GapKind is a guess at what the bytes in a part of the file that nothing points to are.
+*/
+type GapKind int
+
+const (
+	// UnknownGap is data we can not say anything about, often left behind when an editing tool rewrites a file.
+	UnknownGap GapKind = iota
+	// ZeroPadding is a run of zero bytes.
+	ZeroPadding
+	// AlignmentPadding is a single byte so that what follows starts on a word boundary.
+	AlignmentPadding
+	// TextGap is printable ascii, possibly followed by nulls.
+	TextGap
+)
+
+func (k GapKind) String() string {
+	switch k {
+	case ZeroPadding:
+		return "zero padding"
+	case AlignmentPadding:
+		return "alignment padding"
+	case TextGap:
+		return "text"
+	}
+	return "unknown"
+}
+
+/*
+Gap describes a part of the file that nothing points to. Head and Tail are its first and last bytes, Tail is empty
+when Head holds all of it.
+*/
+type Gap struct {
+	Kind GapKind
+	Head []byte
+	Tail []byte
+	// After is the part of the file just before the gap, nil at the start of the file.
+	After parser.Region
+	// Unscanned is set when the gap is too long to look through, so its kind is not known.
+	Unscanned bool
+}
+
+// the shortest run of text that is called text rather than unknown data.
+const minTextGap = 4
+
+/*
+classifyGaps reads each part of the file that nothing claimed to work out what it looks like.
+*/
+func (doc *Document) classifyGaps(in io.ReadSeeker) {
+	doc.Gaps = map[*parser.Unknown]*Gap{}
+	var previous parser.Region
+	for _, n := range doc.Root.Leaves() {
+		if u, ok := n.(*parser.Unknown); ok {
+			gap, err := readGap(in, u.Start, u.End, previous)
+			if err != nil {
+				doc.addDiagnostic(Warning, u.Start, nil, "could not read un-parsed section, %v", err)
+			} else {
+				doc.Gaps[u] = gap
+			}
+		}
+		previous = n
+	}
+}
+
+// the longest gap that is looked through to work out what kind of gap it is, anything longer is not scanned.
+const maxGapScan = 1024 * 1024
+
+/*
+readGap reads the ends of the bytes between start and end to show and scans them a chunk at a time to work out what
+kind of gap it is. after is the part of the file just before the gap.
+*/
+func readGap(in io.ReadSeeker, start int64, end int64, after parser.Region) (*Gap, error) {
+	size := end - start
+	if size <= 0 {
+		return &Gap{After: after}, nil
+	}
+
+	result := Gap{After: after}
+	headSize := size
+	if size > 2*dataPreviewSize {
+		headSize = dataPreviewSize
+	}
+	head, err := readAt(in, start, headSize)
+	if err != nil {
+		return nil, err
+	}
+	result.Head = head
+	if headSize < size {
+		tail, err := readAt(in, end-dataPreviewSize, dataPreviewSize)
+		if err != nil {
+			return nil, err
+		}
+		result.Tail = tail
+	}
+
+	if size == 1 && head[0] == 0 && end%2 == 0 && isPadded(after) {
+		result.Kind = AlignmentPadding
+		return &result, nil
+	}
+	if size > maxGapScan {
+		result.Unscanned = true
+		return &result, nil
+	}
+
+	if _, err := in.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	allZero := true
+	allText := true
+	// text may be followed by nulls but nothing else
+	inNulls := false
+	chunk := make([]byte, min64(size, 64*1024))
+	for position := int64(0); position < size && (allZero || allText); {
+		c := chunk
+		if size-position < int64(len(c)) {
+			c = c[:size-position]
+		}
+		if _, err := io.ReadFull(in, c); err != nil {
+			return nil, err
+		}
+
+		for _, b := range c {
+			if b != 0 {
+				allZero = false
+			}
+			if b == 0 {
+				inNulls = true
+			} else if inNulls || !isText(b) {
+				allText = false
+			}
+		}
+		position += int64(len(c))
+	}
+
+	switch {
+	case allZero:
+		result.Kind = ZeroPadding
+	case allText && size >= minTextGap:
+		result.Kind = TextGap
+	}
+	return &result, nil
+}
+
+/*
+isPadded says if a region is one that writers pad to a word boundary, an IFD or the values a field points to.
+*/
+func isPadded(r parser.Region) bool {
+	switch r.(type) {
+	case *IFD, *Offset:
+		return true
+	}
+	return false
+}
+
+func isText(b byte) bool {
+	return (b >= 0x20 && b < 0x7f) || b == '\t' || b == '\n' || b == '\r'
+}
+
+func min64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func renderUnknown(u *parser.Unknown, gap *Gap) []payload.Section {
+	if gap == nil {
+		return []payload.Section{&payload.General{Start: u.Start, End: u.End - 1, Id: "unknown", Text: "Un-parsed section"}}
+	}
+
+	size := u.End - u.Start
+	var desc string
+	switch gap.Kind {
+	case AlignmentPadding:
+		desc = "A byte of padding so that what follows starts on a word boundary"
+		if gap.After != nil {
+			start, _ := gap.After.Range()
+			desc += fmt.Sprintf(", after <a href=\"#%v\">%v</a>", start, template.HTMLEscapeString(describeRegion(gap.After)))
+		}
+	case ZeroPadding:
+		desc = fmt.Sprintf("%v bytes of zeros that nothing points to, most likely padding", size)
+	case TextGap:
+		desc = fmt.Sprintf("%v bytes of ascii text that nothing points to, it could be a comment or left over from an editing tool", size)
+	default:
+		desc = fmt.Sprintf("Un-parsed section, %v bytes that nothing points to. This could be left over from an editing tool or something tiffhax does not understand", size)
+		if gap.Unscanned {
+			desc += ". It was not scanned as it is larger than 1MB"
+		}
+	}
+
+	return []payload.Section{&payload.General{
+		Start:   u.Start,
+		End:     u.End - 1,
+		Id:      "unknown",
+		TheData: renderPreview(u.Start, u.End, gap.Head, gap.Tail, true, nil),
+		Text:    template.HTML(desc),
+	}}
+}
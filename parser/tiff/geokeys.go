@@ -0,0 +1,222 @@
+package tiff
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/emilyselwood/tiffhax/parser/tiff/constants"
+	"github.com/emilyselwood/tiffhax/payload"
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+GeoKey is a single entry in a GeoTIFF key directory. Location is the tag holding the value, zero when the value is
+stored directly in the entry. Otherwise Value is the index of the first of Count values in that tag.
+*/
+type GeoKey struct {
+	Start    int64
+	Data     []byte
+	ID       uint16
+	Location uint16
+	Count    uint16
+	Value    uint16
+}
+
+/*
+renderGeoKeyDirectory splits the GeoKeyDirectoryTag (34735) into its header and one row per key, resolving any values
+that live in the GeoDoubleParamsTag (34736) or GeoAsciiParamsTag (34737).
+*/
+func (o *Offset) renderGeoKeyDirectory() ([]payload.Section, error) {
+	order := o.Order
+	version := order.Uint16(o.Data[0:2])
+	revision := order.Uint16(o.Data[2:4])
+	minor := order.Uint16(o.Data[4:6])
+	count := order.Uint16(o.Data[6:8])
+
+	var data bytes.Buffer
+	payload.RenderBytesSpan(&data, o.Data[0:2], "geokey_header")
+	data.WriteRune(' ')
+	payload.RenderBytesSpan(&data, o.Data[2:4], "geokey_header")
+	data.WriteRune(' ')
+	payload.RenderBytesSpan(&data, o.Data[4:6], "geokey_header")
+	data.WriteRune(' ')
+	payload.RenderBytesSpan(&data, o.Data[6:8], "field_count")
+
+	desc := fmt.Sprintf(
+		"The header of the <a href=\"#%v\">GeoKeyDirectoryTag</a>, version <span class=\"geokey_header\">%v</span> revision <span class=\"geokey_header\">%v.%v</span> containing <span class=\"field_count\">%v</span> keys",
+		o.From, version, revision, minor, count,
+	)
+
+	result := []payload.Section{
+		&payload.General{
+			Start:   o.Start,
+			End:     o.Start + 7,
+			Id:      "geokey_header",
+			TheData: template.HTML(data.String()),
+			Text:    template.HTML(desc),
+		},
+	}
+
+	for i := 0; i < int(count); i++ {
+		start := 8 + (i * 8)
+		if start+8 > len(o.Data) {
+			break
+		}
+		entry := o.Data[start : start+8]
+		key := GeoKey{
+			Start:    o.Start + int64(start),
+			Data:     entry,
+			ID:       order.Uint16(entry[0:2]),
+			Location: order.Uint16(entry[2:4]),
+			Count:    order.Uint16(entry[4:6]),
+			Value:    order.Uint16(entry[6:8]),
+		}
+		section, err := o.renderGeoKey(&key)
+		if err != nil {
+			return nil, fmt.Errorf("could not render geo key %v, %v", i, err)
+		}
+		result = append(result, section)
+	}
+
+	// anything left over after the keys
+	used := 8 + (int(count) * 8)
+	if used < len(o.Data) {
+		var rest bytes.Buffer
+		payload.RenderByteBlocks(&rest, o.Data[used:], 2, []string{"offset_a", "offset_b", "offset_c"})
+		result = append(result, &payload.General{
+			Start:   o.Start + int64(used),
+			End:     o.End - 1,
+			Id:      "geokey_values",
+			TheData: template.HTML(rest.String()),
+			Text:    template.HTML("Values stored in the GeoKeyDirectoryTag after the keys"),
+		})
+	}
+
+	return result, nil
+}
+
+func (o *Offset) renderGeoKey(key *GeoKey) (payload.Section, error) {
+	desc, err := payload.RenderTemplate(geoKeyTemplate, key, template.FuncMap{
+		"GeoKeyName": func(id uint16) string {
+			return constants.GeoKeyNames[id]
+		},
+		"LocationName": func() string {
+			if key.Location == 34735 {
+				return "this directory"
+			}
+			return fieldName(o.IFD, key.Location)
+		},
+		"ValueName": func() string {
+			if names, ok := constants.GeoKeyValueLookup[key.ID]; ok {
+				if name, ok := names[key.Value]; ok {
+					return " which means " + name
+				}
+			}
+			if constants.GeoKeysWithEPSGCodes[key.ID] {
+				if name, ok := constants.EPSGName(key.Value); ok {
+					return " which is EPSG:" + strconv.Itoa(int(key.Value)) + " " + name
+				}
+			}
+			return ""
+		},
+		"ParamsLink": func() string {
+			return o.geoKeyParamsLink(key)
+		},
+		"ParamsValues": func() string {
+			return o.geoKeyParamsValues(key)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not render geo key description, %v", err)
+	}
+
+	var data bytes.Buffer
+	payload.RenderBytesSpan(&data, key.Data[0:2], "field_id")
+	data.WriteRune(' ')
+	payload.RenderBytesSpan(&data, key.Data[2:4], "field_type")
+	data.WriteRune(' ')
+	payload.RenderBytesSpan(&data, key.Data[4:6], "field_count")
+	data.WriteRune(' ')
+	payload.RenderBytesSpan(&data, key.Data[6:8], "field_value")
+
+	return &payload.General{
+		Start:   key.Start,
+		End:     key.Start + 7,
+		Id:      "geokey",
+		TheData: template.HTML(data.String()),
+		Text:    template.HTML(desc),
+	}, nil
+}
+
+/*
+geoKeyParamsLink works out the anchor for the first value of a key that is stored in another tag.
+*/
+func (o *Offset) geoKeyParamsLink(key *GeoKey) string {
+	if key.Location == 34735 {
+		// link to the row in this directory containing the value
+		position := int64(key.Value) * 2
+		return strconv.FormatInt(o.Start+position-(position%8), 10)
+	}
+
+	field, err := o.IFD.FindField(key.Location)
+	if err != nil {
+		return strconv.FormatInt(o.From, 10)
+	}
+	if !field.IsOffset {
+		return strconv.FormatInt(field.Start, 10)
+	}
+	_, start := field.valueBytes()
+	return payload.ByteAnchor(start + int64(key.Value)*int64(constants.DataTypeSize[field.DType]))
+}
+
+/*
+geoKeyParamsValues decodes the values of a key that are stored in another tag.
+*/
+func (o *Offset) geoKeyParamsValues(key *GeoKey) string {
+	var values []byte
+	var size int
+	if key.Location == 34735 {
+		values = o.Data
+		size = 2
+	} else {
+		field, err := o.IFD.FindField(key.Location)
+		if err != nil {
+			return fmt.Sprintf(" but there is no %v field", fieldName(o.IFD, key.Location))
+		}
+		values, _ = field.valueBytes()
+		size = int(constants.DataTypeSize[field.DType])
+	}
+
+	start := int(key.Value) * size
+	end := start + (int(key.Count) * size)
+	if size == 0 || end > len(values) {
+		return " which runs past the end of the values"
+	}
+	values = values[start:end]
+
+	switch key.Location {
+	case 34737:
+		// ascii params are separated with a pipe
+		return fmt.Sprintf(": %q", strings.TrimRight(string(values), "|\x00"))
+	case 34736:
+		var parts []string
+		for i := 0; i+8 <= len(values); i += 8 {
+			parts = append(parts, formatDouble(math.Float64frombits(o.Order.Uint64(values[i:i+8]))))
+		}
+		return ": " + strings.Join(parts, ", ")
+	case 34735:
+		var parts []string
+		for i := 0; i+2 <= len(values); i += 2 {
+			parts = append(parts, strconv.Itoa(int(o.Order.Uint16(values[i:i+2]))))
+		}
+		return ": " + strings.Join(parts, ", ")
+	}
+	return ""
+}
+
+const geoKeyTemplate = `GeoKey <span class="field_id">{{ GeoKeyName .ID }}</span> ({{ .ID }})
+{{ if eq .Location 0 }}has the value <span class="field_value">{{ .Value }}</span>{{ ValueName }}
+{{ else }}is <span class="field_count">{{ .Count }}</span> values from <a href="#{{ ParamsLink }}">{{ LocationName }}</a>
+starting at index <span class="field_value">{{ .Value }}</span>{{ ParamsValues }}{{ end }}`
@@ -0,0 +1,63 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGeoKeyDirectory(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			doc := parseTest(t, geoTIFF(order))
+			if len(doc.Diagnostics) != 0 {
+				t.Errorf("unexpected diagnostics: %v", doc.Diagnostics)
+			}
+			ifd := doc.IFDs[0]
+			directory := findField(t, ifd, 34735).Offset
+			doubles := findField(t, ifd, 34736).Offset
+			ascii := findField(t, ifd, 34737).Offset
+
+			sections, err := directory.render()
+			if err != nil {
+				t.Fatalf("could not render: %v", err)
+			}
+			if len(sections) != 6 {
+				t.Fatalf("got %v sections expected a header and 5 keys", len(sections))
+			}
+			if header := string(sections[0].Description()); !strings.Contains(header, "containing <span class=\"field_count\">5</span> keys") {
+				t.Errorf("header was %v", header)
+			}
+
+			tests := []struct {
+				name     string
+				contains []string
+			}{
+				{"GTModelTypeGeoKey", []string{"has the value", "which means ModelTypeGeographic"}},
+				{"GeographicTypeGeoKey", []string{"which is EPSG:4326 WGS 84"}},
+				{"GeogCitationGeoKey", []string{
+					fmt.Sprintf("<a href=\"#b%v\">GeoAsciiParamsTag</a>", ascii.Start),
+					"WGS 84&#34;",
+				}},
+				{"GeogSemiMajorAxisGeoKey", []string{
+					fmt.Sprintf("<a href=\"#b%v\">GeoDoubleParamsTag</a>", doubles.Start+8),
+					": 6378137",
+				}},
+				{"GeogInvFlatteningGeoKey", []string{"which runs past the end of the values"}},
+			}
+			for i, test := range tests {
+				section := sections[i+1]
+				desc := string(section.Description())
+				if !strings.Contains(desc, test.name) {
+					t.Errorf("key %v was %v expected %v", i, desc, test.name)
+				}
+				for _, part := range test.contains {
+					if !strings.Contains(desc, part) {
+						t.Errorf("%v should contain %q, got %v", test.name, part, desc)
+					}
+				}
+			}
+		})
+	}
+}
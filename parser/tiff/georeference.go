@@ -0,0 +1,163 @@
+package tiff
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"strconv"
+)
+
+/*
+modelCorner is the position of one corner of the image in model space.
+*/
+type modelCorner struct {
+	Name string
+	I    float64
+	J    float64
+	X    float64
+	Y    float64
+}
+
+/*
+fieldDoubles decodes the values of a field in the same IFD as doubles, returning nil if the field is not made of doubles.
+*/
+func (o *Offset) fieldDoubles(id uint16) []float64 {
+	field, err := o.IFD.FindField(id)
+	if err != nil || field.DType != 12 {
+		return nil
+	}
+	data, _ := field.valueBytes()
+	var result []float64
+	for i := 0; i+8 <= len(data); i += 8 {
+		result = append(result, math.Float64frombits(o.Order.Uint64(data[i:i+8])))
+	}
+	return result
+}
+
+/*
+describeGeoreferencing decodes the ModelPixelScaleTag (33550), ModelTiepointTag (33922) and ModelTransformationTag
+(34264). For the tie points and transformation it also works out where the corners of the image end up.
+*/
+func (o *Offset) describeGeoreferencing() (template.HTML, bool) {
+	values := o.fieldDoubles(o.FieldId)
+	if values == nil {
+		return "", false
+	}
+
+	var desc bytes.Buffer
+	switch o.FieldId {
+	case 33550:
+		if len(values) < 3 {
+			return " which is too short to hold a pixel scale", true
+		}
+		_, _ = fmt.Fprintf(&desc, " which gives a pixel size of %v by %v (z scale %v) in model units",
+			formatDouble(values[0]), formatDouble(values[1]), formatDouble(values[2]))
+	case 33922:
+		desc.WriteString(" which tie raster points to model points:")
+		for i := 0; i+6 <= len(values); i += 6 {
+			_, _ = fmt.Fprintf(&desc, "<br />raster (%v, %v, %v) is model (%v, %v, %v)",
+				formatDouble(values[i]), formatDouble(values[i+1]), formatDouble(values[i+2]),
+				formatDouble(values[i+3]), formatDouble(values[i+4]), formatDouble(values[i+5]))
+		}
+		if len(values)%6 != 0 {
+			_, _ = fmt.Fprintf(&desc, "<br />there are %v values left over which do not make a full tie point", len(values)%6)
+		}
+	case 34264:
+		if len(values) < 16 {
+			return " which is too short to hold a 4x4 matrix", true
+		}
+		desc.WriteString(" which make the transformation matrix:")
+		for row := 0; row < 4; row++ {
+			_, _ = fmt.Fprintf(&desc, "<br />[ %v %v %v %v ]",
+				formatDouble(values[row*4]), formatDouble(values[row*4+1]),
+				formatDouble(values[row*4+2]), formatDouble(values[row*4+3]))
+		}
+	default:
+		return "", false
+	}
+
+	if o.FieldId != 33550 {
+		corners, err := o.modelCorners()
+		if err != nil {
+			_, _ = fmt.Fprintf(&desc, "<br />Could not work out the image corners: %v", template.HTMLEscapeString(err.Error()))
+		} else {
+			desc.WriteString("<br />Which puts the corners of the image at:")
+			for _, c := range corners {
+				_, _ = fmt.Fprintf(&desc, "<br />%v pixel (%v, %v) at model (%v, %v)",
+					c.Name, formatDouble(c.I), formatDouble(c.J), formatDouble(c.X), formatDouble(c.Y))
+			}
+		}
+	}
+
+	return template.HTML(desc.String()), true
+}
+
+/*
+modelCorners maps the corners of the image into model space using either the transformation matrix or the first tie
+point and the pixel scale.
+*/
+func (o *Offset) modelCorners() ([]modelCorner, error) {
+	width, err := o.IFD.FieldValue(256)
+	if err != nil {
+		return nil, fmt.Errorf("no image width, %v", err)
+	}
+	length, err := o.IFD.FieldValue(257)
+	if err != nil {
+		return nil, fmt.Errorf("no image length, %v", err)
+	}
+
+	var transform func(i float64, j float64) (float64, float64)
+	if matrix := o.fieldDoubles(34264); len(matrix) >= 16 {
+		transform = func(i float64, j float64) (float64, float64) {
+			return matrix[0]*i + matrix[1]*j + matrix[3], matrix[4]*i + matrix[5]*j + matrix[7]
+		}
+	} else {
+		tiePoints := o.fieldDoubles(33922)
+		scale := o.fieldDoubles(33550)
+		if len(tiePoints) < 6 {
+			return nil, fmt.Errorf("no tie point")
+		}
+		if len(scale) < 2 {
+			return nil, fmt.Errorf("there are tie points but no pixel scale")
+		}
+		transform = func(i float64, j float64) (float64, float64) {
+			// model y goes up as raster j goes down
+			return tiePoints[3] + (i-tiePoints[0])*scale[0], tiePoints[4] - (j-tiePoints[1])*scale[1]
+		}
+	}
+
+	w := float64(width)
+	l := float64(length)
+	corners := []modelCorner{
+		{Name: "upper left", I: 0, J: 0},
+		{Name: "upper right", I: w, J: 0},
+		{Name: "lower left", I: 0, J: l},
+		{Name: "lower right", I: w, J: l},
+	}
+	for i := range corners {
+		corners[i].X, corners[i].Y = transform(corners[i].I, corners[i].J)
+	}
+	return corners, nil
+}
+
+/*
+georeferenceBlockSize groups the bytes of the tie points by raster and model point, and the transformation matrix by
+row, so they are easier to pick out in the hex.
+*/
+func (o *Offset) georeferenceBlockSize() (int, bool) {
+	if o.DType != 12 {
+		return 0, false
+	}
+	switch o.FieldId {
+	case 33922:
+		return 24, true
+	case 34264:
+		return 32, true
+	}
+	return 0, false
+}
+
+func formatDouble(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,38 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestGeoreferenceCorners(t *testing.T) {
+	doc := parseTest(t, geoTIFF(binary.LittleEndian))
+	ifd := doc.IFDs[0]
+
+	tests := []struct {
+		id       uint16
+		contains []string
+	}{
+		{33550, []string{"which gives a pixel size of 0.5 by 0.25 (z scale 0) in model units"}},
+		{33922, []string{
+			"raster (0, 0, 0) is model (100, 200, 0)",
+			"upper left pixel (0, 0) at model (100, 200)",
+			"upper right pixel (4, 0) at model (102, 200)",
+			"lower left pixel (0, 4) at model (100, 199)",
+			"lower right pixel (4, 4) at model (102, 199)",
+		}},
+	}
+	for _, test := range tests {
+		sections, err := findField(t, ifd, test.id).Offset.render()
+		if err != nil {
+			t.Fatalf("could not render %v: %v", test.id, err)
+		}
+		desc := string(sections[0].Description())
+		for _, part := range test.contains {
+			if !strings.Contains(desc, part) {
+				t.Errorf("%v should contain %q, got %v", test.id, part, desc)
+			}
+		}
+	}
+}
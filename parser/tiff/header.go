@@ -12,12 +12,17 @@ import (
 
 
 type Header struct {
+	parser.Sharing
+
 	Start          int64
 	End            int64
 	Data           []byte
 	Endian         binary.ByteOrder
 	BigTiff        bool
+	OffsetSize     uint16
 	FirstIFDOffset int64
+	// FirstIFDPastEnd is how many bytes past the end of the file the first ifd offset goes.
+	FirstIFDPastEnd uint64
 }
 
 func ParseHeader(in io.Reader) (*Header, int64, error) {
@@ -40,16 +45,17 @@ func ParseHeader(in io.Reader) (*Header, int64, error) {
 	} else if data[0] == 'I' && data[1] == 'I' {
 		result.Endian = binary.LittleEndian
 	} else {
-		result.Endian = nil
+		return &result, 8, fmt.Errorf("not a tiff file, byte order was %q expected II or MM", data[0:2])
 	}
 
 	magic := result.Endian.Uint16(data[2:4])
-	if magic == 43{
-		return &result, 8, fmt.Errorf("parsing bigtiff file not yet implemented")
-	}	
+	if magic == 43 {
+		return parseBigTiffHeader(in, &result)
+	}
 	if magic != 42 {
 		return &result, 8, fmt.Errorf("not a tiff file, magic number was %v expected 42", magic)
 	}
+	result.OffsetSize = 4
 	result.FirstIFDOffset = int64(result.Endian.Uint32(data[4:8]))
 
 	result.Start = 0
@@ -59,6 +65,76 @@ func ParseHeader(in io.Reader) (*Header, int64, error) {
 
 }
 
+/*
+parseBigTiffHeader reads the rest of a 16 byte big tiff header. The first 8 bytes have already been read into result.
+After the magic number comes the size of offsets (always 8), a reserved word (always 0) and the 8 byte offset of the
+first IFD.
+*/
+func parseBigTiffHeader(in io.Reader, result *Header) (*Header, int64, error) {
+	data := make([]byte, 8)
+
+	n, err := in.Read(data)
+	if err != nil {
+		return result, 8 + int64(n), fmt.Errorf("could not read big tiff header, %v", err)
+	}
+	if n != 8 {
+		return result, 8 + int64(n), fmt.Errorf("not enough data for big tiff header")
+	}
+
+	result.Data = append(result.Data, data...)
+	result.BigTiff = true
+	result.Start = 0
+	result.End = 16
+
+	result.OffsetSize = result.Endian.Uint16(result.Data[4:6])
+	if result.OffsetSize != 8 {
+		return result, 16, fmt.Errorf("big tiff offset byte size was %v expected 8", result.OffsetSize)
+	}
+	if reserved := result.Endian.Uint16(result.Data[6:8]); reserved != 0 {
+		return result, 16, fmt.Errorf("big tiff reserved header word was %v expected 0", reserved)
+	}
+	result.FirstIFDOffset = int64(result.Endian.Uint64(result.Data[8:16]))
+
+	return result, int64(len(result.Data)), nil
+}
+
+/*
+ifdCountSize is the number of bytes used to hold the number of fields at the start of an IFD.
+*/
+func (h *Header) ifdCountSize() int64 {
+	if h.BigTiff {
+		return 8
+	}
+	return 2
+}
+
+/*
+fieldSize is the number of bytes each field in an IFD takes up.
+*/
+func (h *Header) fieldSize() int64 {
+	if h.BigTiff {
+		return 20
+	}
+	return 12
+}
+
+/*
+readOffset decodes an offset sized value, 4 bytes for a normal tiff and 8 for a big tiff.
+*/
+func (h *Header) readOffset(buf []byte) uint64 {
+	if h.BigTiff {
+		return h.Endian.Uint64(buf)
+	}
+	return uint64(h.Endian.Uint32(buf))
+}
+
+/*
+Range returns the start of the header and one past its end.
+*/
+func (h *Header) Range() (int64, int64) {
+	return h.Start, h.End
+}
+
 func (h *Header) Contains(offset int64) bool {
 	return h.Start <= offset && offset < h.End
 }
@@ -78,7 +154,7 @@ func (h *Header) Split(start int64, end int64, newBit parser.Region) error {
 	return fmt.Errorf("header can not be split")
 }
 
-func (h *Header) Render() ([]payload.Section, error) {
+func (h *Header) render() ([]payload.Section, error) {
 	desc, err := payload.RenderTemplate(headerTemplate, h, template.FuncMap{})
 	if err != nil {
 		return nil, fmt.Errorf("could not render header description, %v", err)
@@ -90,7 +166,15 @@ func (h *Header) Render() ([]payload.Section, error) {
 	data.WriteRune(' ')
 	payload.RenderBytesSpan(&data, h.Data[2:4], "header_magic")
 	data.WriteRune(' ')
-	payload.RenderBytesSpan(&data, h.Data[4:8], "header_offset")
+	if h.BigTiff {
+		payload.RenderBytesSpan(&data, h.Data[4:6], "header_bytesize")
+		data.WriteRune(' ')
+		payload.RenderBytesSpan(&data, h.Data[6:8], "header_reserved")
+		data.WriteRune(' ')
+		payload.RenderBytesSpan(&data, h.Data[8:16], "header_offset")
+	} else {
+		payload.RenderBytesSpan(&data, h.Data[4:8], "header_offset")
+	}
 
 	return []payload.Section{
 		&payload.General{
@@ -103,4 +187,5 @@ func (h *Header) Render() ([]payload.Section, error) {
 	}, nil
 }
 
-const headerTemplate = `The header shows this is a <span class="header_magic">tiff file</span> is in <span class="header_endian">{{.Endian}}</span> format {{if .BigTiff}} and is a big tiff {{end}} The first IDF starts at byte <span class="header_offset"><a href="#{{.FirstIFDOffset}}">{{.FirstIFDOffset}}</a></span>`
+const headerTemplate = `The header shows this is a <span class="header_magic">tiff file</span> is in <span class="header_endian">{{.Endian}}</span> format {{if .BigTiff}} and is a big tiff with <span class="header_bytesize">{{.OffsetSize}}</span> byte offsets and a <span class="header_reserved">reserved</span> word.{{end}} The first IDF starts at byte <span class="header_offset"><a href="#{{.FirstIFDOffset}}">{{.FirstIFDOffset}}</a></span>
+{{ if .FirstIFDPastEnd }} <span class="past_end">which is past the end of the file by {{ .FirstIFDPastEnd }} bytes</span>{{ end }}`
@@ -0,0 +1,68 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		builder    *testBuilder
+		endian     binary.ByteOrder
+		bigTiff    bool
+		offsetSize uint16
+		length     int64
+	}{
+		{"little endian", newTestBuilder(binary.LittleEndian, false), binary.LittleEndian, false, 4, 8},
+		{"big endian", newTestBuilder(binary.BigEndian, false), binary.BigEndian, false, 4, 8},
+		{"big tiff", newTestBuilder(binary.LittleEndian, true), binary.LittleEndian, true, 8, 16},
+		{"big endian big tiff", newTestBuilder(binary.BigEndian, true), binary.BigEndian, true, 8, 16},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.builder.setFirstIFD(1234)
+			header, l, err := ParseHeader(test.builder.reader())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if l != test.length || header.End != test.length {
+				t.Errorf("header length was %v ending at %v, expected %v", l, header.End, test.length)
+			}
+			if header.Endian != test.endian {
+				t.Errorf("byte order was %v expected %v", header.Endian, test.endian)
+			}
+			if header.BigTiff != test.bigTiff || header.OffsetSize != test.offsetSize {
+				t.Errorf("big tiff was %v with %v byte offsets, expected %v with %v", header.BigTiff, header.OffsetSize, test.bigTiff, test.offsetSize)
+			}
+			if header.FirstIFDOffset != 1234 {
+				t.Errorf("first ifd offset was %v expected 1234", header.FirstIFDOffset)
+			}
+		})
+	}
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"too short", []byte{'I', 'I', 42, 0}},
+		{"bad byte order", []byte{'X', 'X', 42, 0, 8, 0, 0, 0}},
+		{"bad magic", []byte{'I', 'I', 41, 0, 8, 0, 0, 0}},
+		{"short big tiff", []byte{'I', 'I', 43, 0, 8, 0, 0, 0}},
+		{"big tiff offset size", []byte{'I', 'I', 43, 0, 4, 0, 0, 0, 16, 0, 0, 0, 0, 0, 0, 0}},
+		{"big tiff reserved", []byte{'I', 'I', 43, 0, 8, 0, 1, 0, 16, 0, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := ParseHeader(bytes.NewReader(test.data)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
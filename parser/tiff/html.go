@@ -0,0 +1,203 @@
+package tiff
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/emilyselwood/tiffhax/parser"
+	"github.com/emilyselwood/tiffhax/payload"
+	"html/template"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+RenderHTML turns a document into the sections shown on the page, one or more for each part of the file in order.
+*/
+func RenderHTML(doc *Document) ([]payload.Section, error) {
+	var result []payload.Section
+	for _, n := range doc.Nodes() {
+		sections, err := renderNode(doc, n)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, sections...)
+	}
+	return result, nil
+}
+
+func renderNode(doc *Document, n Node) ([]payload.Section, error) {
+	var region parser.Region
+	var sections []payload.Section
+	var err error
+	switch node := n.(type) {
+	case *Header:
+		region = node
+		sections, err = node.render()
+	case *IFD:
+		region = node
+		sections, err = node.render()
+	case *Field:
+		region = node
+		sections, err = node.render()
+	case *Offset:
+		region = node
+		sections, err = node.render()
+	case *Data:
+		region = node
+		sections, err = node.render()
+	case *parser.Unknown:
+		return renderUnknown(node, doc.Gaps[node]), nil
+	default:
+		return nil, fmt.Errorf("do not know how to render a %v", reflect.TypeOf(n))
+	}
+	if err != nil {
+		return sections, err
+	}
+	return renderSharing(sections, region), nil
+}
+
+/*
+renderSharing adds a note to the first section of a region listing anything else that claims the same bytes, with
+partial overlaps highlighted as conflicts.
+*/
+func renderSharing(sections []payload.Section, r parser.Region) []payload.Section {
+	shared := r.Shared()
+	if len(sections) == 0 || (len(shared.Aliases) == 0 && len(shared.Conflicts) == 0) {
+		return sections
+	}
+	first, ok := sections[0].(*payload.General)
+	if !ok {
+		return sections
+	}
+
+	var note bytes.Buffer
+	note.WriteString(string(first.Text))
+	if len(shared.Aliases) > 0 {
+		note.WriteString("<br /><span class=\"region_shared\">These bytes are also used by ")
+		note.WriteString(describeRegions(shared.Aliases, referrerStart))
+		note.WriteString("</span>")
+	}
+	if len(shared.Conflicts) > 0 {
+		note.WriteString("<br /><span class=\"region_conflict\">Some of these bytes are also claimed by ")
+		note.WriteString(describeRegions(shared.Conflicts, regionStart))
+		note.WriteString("</span>")
+	}
+	first.Text = template.HTML(note.String())
+	return sections
+}
+
+/*
+describeRegions lists regions with links to them, link gives the row to link to for each.
+*/
+func describeRegions(regions []parser.Region, link func(parser.Region) int64) string {
+	var parts []string
+	for _, r := range regions {
+		start, end := r.Range()
+		parts = append(parts, fmt.Sprintf("<a href=\"#%v\">%v</a> (%v .. %v)", link(r), template.HTMLEscapeString(describeRegion(r)), start, end-1))
+	}
+	return strings.Join(parts, ", ")
+}
+
+/*
+describeRegion names a part of the file for use in a sentence.
+*/
+func describeRegion(r parser.Region) string {
+	switch node := r.(type) {
+	case *Header:
+		return "the header"
+	case *IFD:
+		return node.Name()
+	case *Field:
+		return fmt.Sprintf("the %v field of %v", node.Name(), node.IFD.Name())
+	case *Offset:
+		return fmt.Sprintf("the values of %v in %v", node.Field.Name(), node.IFD.Name())
+	case *Data:
+		if !node.Located {
+			return fmt.Sprintf("entry %v of %v in %v", node.I, fieldName(node.IFD, node.OffsetsFieldId()), node.IFD.Name())
+		}
+		if node.IsTile {
+			return fmt.Sprintf("tile (%v,%v) of plane %v in %v", node.X, node.Y, node.Plane, node.IFD.Name())
+		}
+		return fmt.Sprintf("strip %v of plane %v in %v", node.Y, node.Plane, node.IFD.Name())
+	}
+	start, _ := r.Range()
+	return fmt.Sprintf("the region at %v", start)
+}
+
+/*
+regionStart is the row to link to for a region that has a row of its own, which regions that partly overlap others do.
+*/
+func regionStart(r parser.Region) int64 {
+	start, _ := r.Range()
+	return start
+}
+
+/*
+referrerStart is the row to link to for a region that shares bytes with another. Only the first region to claim the
+bytes gets a row, so link to whatever pointed at the others instead.
+*/
+func referrerStart(r parser.Region) int64 {
+	switch node := r.(type) {
+	case *IFD:
+		if node.Previous != nil {
+			return node.Previous.End - int64(len(node.Previous.FooterData))
+		}
+		if node.ParentField != nil {
+			return node.ParentField.Start
+		}
+		return 0
+	case *Offset:
+		return node.Field.Start
+	case *Data:
+		start, _ := node.referrer().Range()
+		return start
+	}
+	start, _ := r.Range()
+	return start
+}
+
+/*
+renderPreview shows the first and last bytes of a part of the file. The bytes in between are left to be loaded from the
+server a page at a time, as there can be far too many to put in the page. With ascii each row is followed by its bytes
+as text. Annotations, relative to start, are used to mark up the bytes when there are any.
+*/
+func renderPreview(start int64, end int64, head []byte, tail []byte, ascii bool, annotations []payload.Annotation) template.HTML {
+	render := func(in []byte, offset int64) string {
+		if ascii {
+			return payload.RenderHexDump(in)
+		}
+		if len(annotations) > 0 {
+			return payload.RenderAnnotatedBytes(in, offset, annotations)
+		}
+		return payload.RenderBytes(in)
+	}
+
+	var result bytes.Buffer
+	result.WriteString(render(head, 0))
+	if len(tail) == 0 {
+		return template.HTML(result.String())
+	}
+
+	hiddenStart := start + int64(len(head))
+	hiddenEnd := end - int64(len(tail))
+	_, _ = fmt.Fprintf(&result, `<div class="data_more" data-start="%v" data-end="%v" data-ascii="%v"><span class="data_hidden">%v bytes not shown</span> <button class="load_more">load more</button></div>`, hiddenStart, hiddenEnd, ascii, hiddenEnd-hiddenStart)
+	result.WriteString(render(tail, hiddenEnd-start))
+	return template.HTML(result.String())
+}
+
+/*
+RenderDiagnostics converts the diagnostics of a document for display alongside the sections.
+*/
+func RenderDiagnostics(doc *Document) []payload.Diagnostic {
+	var result []payload.Diagnostic
+	for _, d := range doc.Diagnostics {
+		result = append(result, payload.Diagnostic{
+			Severity: d.Severity.String(),
+			Offset:   d.Offset,
+			Link:     strconv.FormatInt(d.Link(), 10),
+			Message:  d.Message,
+		})
+	}
+	return result
+}
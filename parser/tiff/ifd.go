@@ -2,57 +2,172 @@ package tiff
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"github.com/emilyselwood/tiffhax/parser"
+	"github.com/emilyselwood/tiffhax/parser/tiff/constants"
 	"github.com/emilyselwood/tiffhax/payload"
 	"html/template"
 	"io"
+	"io/ioutil"
+	"math"
 	"reflect"
 )
 
 type IFD struct {
+	parser.Sharing
+
 	Start int64
 	End   int64
 	HeaderData  []byte
 	FooterData  []byte
-	Count uint16
+	Count uint64
 	Children []*Field
-	Next uint32
+	Next uint64
+	Kind IFDKind
+	// Parent and ParentField are the IFD and the field that pointed at this one when it is a sub IFD.
+	Parent *IFD
+	ParentField *Field
+	// Previous is the IFD whose next pointer led here.
+	Previous *IFD
+	// Index is the position of this IFD in the top level chain, or amongst the sub IFDs of the same kind in its parent.
+	Index int
+	SubIFDs []*IFD
+	// NextPastEnd is how many bytes past the end of the file the next ifd pointer goes.
+	NextPastEnd uint64
+	// NotLoaded is how many fields at the end were skipped because of the limits, Children only holds the rest.
+	NotLoaded uint64
+}
+
+/*
+IFDKind says what sort of directory an IFD is. The EXIF, GPS and Interoperability directories reuse field ids with
+different meanings, so we need to know which one we are in to name the fields.
+*/
+type IFDKind int
+
+const (
+	ImageIFD IFDKind = iota
+	ExifIFD
+	GPSIFD
+	InteropIFD
+)
+
+// subIFDPointers lists the fields whose value is the offset of a sub IFD, and what sort of IFD they point to.
+var subIFDPointers = map[uint16]IFDKind{
+	330:   ImageIFD,
+	34665: ExifIFD,
+	34853: GPSIFD,
+	40965: InteropIFD,
+}
+
+func (k IFDKind) String() string {
+	switch k {
+	case ExifIFD:
+		return "EXIF"
+	case GPSIFD:
+		return "GPS"
+	case InteropIFD:
+		return "Interoperability"
+	}
+	return "Image"
+}
+
+/*
+describe names the kind of IFD for use in a sentence.
+*/
+func (k IFDKind) describe() string {
+	if k == ImageIFD {
+		return "SubIFD"
+	}
+	return k.String() + " IFD"
+}
+
+/*
+FieldNames returns the dictionary of field names for this kind of IFD.
+*/
+func (k IFDKind) FieldNames() map[uint16]string {
+	switch k {
+	case ExifIFD:
+		return constants.ExifFieldNames
+	case GPSIFD:
+		return constants.GPSFieldNames
+	case InteropIFD:
+		return constants.InteropFieldNames
+	}
+	return constants.FieldNames
+}
+
+/*
+fieldName looks up the name of a field in the dictionary for the IFD it belongs to, falling back to the main tiff
+names as EXIF directories often contain plain tiff fields.
+*/
+func fieldName(ifd *IFD, id uint16) string {
+	if ifd != nil {
+		if name, ok := ifd.Kind.FieldNames()[id]; ok {
+			return name
+		}
+		if ifd.Kind == GPSIFD || ifd.Kind == InteropIFD {
+			return ""
+		}
+	}
+	return constants.FieldNames[id]
 }
 
-func ParseIFD(in io.Reader, start int64, order binary.ByteOrder) (*IFD, int64, []*Offset, []*Data, error) {
-	ifdHeader := make([]byte, 2)
+/*
+ParseIFD reads an IFD using the DefaultLimits.
+*/
+func ParseIFD(in io.Reader, start int64, header *Header) (*IFD, int64, []*Offset, []*Data, error) {
+	return parseIFD(in, start, header, DefaultLimits)
+}
+
+/*
+parseIFD reads an IFD, skipping over any fields beyond the limit rather than reading them.
+*/
+func parseIFD(in io.Reader, start int64, header *Header, limits Limits) (*IFD, int64, []*Offset, []*Data, error) {
+	order := header.Endian
+	ifdHeader := make([]byte, header.ifdCountSize())
 
 	n, err := in.Read(ifdHeader)
 	if err != nil {
 		return nil, int64(n), nil, nil, fmt.Errorf("could not read ifd header, %v", err)
 	}
+	if n != len(ifdHeader) {
+		return nil, int64(n), nil, nil, fmt.Errorf("strange size read from ifd header got %v expected %v", n, len(ifdHeader))
+	}
 
 	var result IFD
 
 	result.Start = start
-	result.Count = order.Uint16(ifdHeader)
-	result.End = start + 6 + (int64(result.Count) * 12)
+	if header.BigTiff {
+		result.Count = order.Uint64(ifdHeader)
+	} else {
+		result.Count = uint64(order.Uint16(ifdHeader))
+	}
+	fieldsStart := start + header.ifdCountSize()
+	result.End = fieldsStart + (int64(result.Count) * header.fieldSize()) + int64(header.OffsetSize)
 	result.HeaderData = ifdHeader
 
+	toRead := result.Count
+	if limits.MaxFieldsPerIFD > 0 && toRead > limits.MaxFieldsPerIFD {
+		toRead = limits.MaxFieldsPerIFD
+		result.NotLoaded = result.Count - toRead
+	}
+
 	// Now read the fields for the IFD
 	var offsets []*Offset
 	var data []*Data
-	for i := 0; i < int(result.Count); i++ {
-		fieldStart := start + 2 + (int64(i) * 12)
-		field, offset, d, err := ParseField(in, fieldStart, order)
+	for i := 0; uint64(i) < toRead; i++ {
+		fieldStart := fieldsStart + (int64(i) * header.fieldSize())
+		field, offset, d, err := ParseField(in, fieldStart, header)
 		if err != nil {
 			return nil, 0, nil, nil, fmt.Errorf("could not parse field %v of ifd, %v", i, err)
 		}
 
+		field.IFD = &result
 		result.Children = append(result.Children, field)
 		if offset != nil {
 			offsets = append(offsets, offset)
 		}
-		if d != nil {
-			data = append(data, d)
-		}
+		data = append(data, d...)
 	}
 
 	for _, o := range offsets {
@@ -63,17 +178,41 @@ func ParseIFD(in io.Reader, start int64, order binary.ByteOrder) (*IFD, int64, [
 		d.IFD = &result
 	}
 
-	nextIFD := make([]byte, 4)
+	if result.NotLoaded > 0 {
+		if err := skip(in, multiplySaturating(result.NotLoaded, uint64(header.fieldSize()))); err != nil {
+			return nil, 0, nil, nil, fmt.Errorf("could not skip the fields past the limit, %v", err)
+		}
+	}
+
+	nextIFD := make([]byte, header.OffsetSize)
 	n, err = in.Read(nextIFD)
 	if err != nil {
 		return nil, int64(n), nil, nil, fmt.Errorf("could not read ifd footer, %v", err)
 	}
+	if n != len(nextIFD) {
+		return nil, int64(n), nil, nil, fmt.Errorf("strange size read from ifd footer got %v expected %v", n, len(nextIFD))
+	}
 
-	result.Next = order.Uint32(nextIFD)
+	result.Next = header.readOffset(nextIFD)
 	result.FooterData = nextIFD
 	return &result, result.End, offsets, data, nil
 }
 
+/*
+skip moves past n bytes of the input without keeping them, seeking when the input allows it.
+*/
+func skip(in io.Reader, n uint64) error {
+	if n > math.MaxInt64 {
+		return fmt.Errorf("can not skip %v bytes", n)
+	}
+	if s, ok := in.(io.Seeker); ok {
+		_, err := s.Seek(int64(n), io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, in, int64(n))
+	return err
+}
+
 func (i *IFD) FindField(id uint16) (*Field, error) {
 	for _, c := range i.Children {
 		if c.ID == id {
@@ -83,6 +222,62 @@ func (i *IFD) FindField(id uint16) (*Field, error) {
 	return nil, fmt.Errorf("could not find field %v in ifd starting at %v", id, i.Start)
 }
 
+/*
+Name gives a human readable name for this IFD based on where it sits in the tree, for example "SubIFD 2 of IFD 0".
+*/
+func (i *IFD) Name() string {
+	if i.Parent == nil {
+		return fmt.Sprintf("IFD %v", i.Index)
+	}
+	if i.Kind != ImageIFD && i.Index == 0 {
+		return fmt.Sprintf("%v of %v", i.Kind.describe(), i.Parent.Name())
+	}
+	return fmt.Sprintf("%v %v of %v", i.Kind.describe(), i.Index, i.Parent.Name())
+}
+
+func (i *IFD) countSubIFDs(kind IFDKind) int {
+	count := 0
+	for _, s := range i.SubIFDs {
+		if s.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+/*
+subIFDs finds the fields in this IFD that point at EXIF, GPS or Interoperability IFDs.
+*/
+func (i *IFD) subIFDs() []*Field {
+	var result []*Field
+	for _, f := range i.Children {
+		if _, ok := f.subIFDKind(); ok {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+/*
+FieldValue returns the value of a field that holds a single value directly in the IFD.
+*/
+func (i *IFD) FieldValue(id uint16) (uint64, error) {
+	field, err := i.FindField(id)
+	if err != nil {
+		return 0, err
+	}
+	if field.IsOffset {
+		return 0, fmt.Errorf("field %v in ifd starting at %v holds more than one value", id, i.Start)
+	}
+	return field.Value, nil
+}
+
+/*
+Range returns the start of the IFD, including its fields and the next IFD pointer and one past its end.
+*/
+func (i *IFD) Range() (int64, int64) {
+	return i.Start, i.End
+}
 
 func (i *IFD) Contains(offset int64) bool {
 	return i.Start <= offset && offset < i.End
@@ -96,20 +291,16 @@ func (i *IFD) Find(offset int64) (parser.Region, error) {
 	if offset < i.Start || offset >= i.End {
 		return nil, fmt.Errorf("find offset %v outside of ifd region %v to %v", offset, i.Start, i.End)
 	}
-	if offset >= i.Start + 2 {
-		if len(i.Children) > 0 {
-			for _, c := range i.Children {
-				if c.Contains(offset) {
-					r, err := c.Find(offset)
-					return r, err
-				}
+	if offset >= i.Start + int64(len(i.HeaderData)) {
+		for _, c := range i.Children {
+			if c.Contains(offset) {
+				r, err := c.Find(offset)
+				return r, err
 			}
-			return nil, fmt.Errorf("find offset %v inside region %v to %v but not contained by children ... bork bork bork", offset, i.Start, i.End)
-		} else {
-			return nil, fmt.Errorf("find offset past ifd header but ifd does not have any children ... bork bork bork")
 		}
 	}
 
+	// the count at the start or the pointer to the next ifd at the end
 	return i, nil
 }
 
@@ -117,7 +308,7 @@ func (i *IFD) Split(start int64, end int64, newBit parser.Region) error {
 	return fmt.Errorf("IFD between %v and %v can not be split between %v and %v to insert a %v", i.Start, i.End, start, end, reflect.TypeOf(newBit))
 }
 
-func (i *IFD) Render() ([]payload.Section, error) {
+func (i *IFD) render() ([]payload.Section, error) {
 	var result []payload.Section
 
 	// ifd header
@@ -129,11 +320,27 @@ func (i *IFD) Render() ([]payload.Section, error) {
 
 	// each field
 	for _, f := range i.Children {
-		childSections, err := f.Render()
+		childSections, err := f.render()
 		if err != nil {
 			return result, fmt.Errorf("could not render ifd field, %v", err)
 		}
-		result = append(result, childSections...)
+		result = append(result, renderSharing(childSections, f)...)
+	}
+
+	// fields past the limit
+	if i.NotLoaded > 0 {
+		footerStart := i.End - int64(len(i.FooterData))
+		skippedStart := i.Start + int64(len(i.HeaderData))
+		if len(i.Children) > 0 {
+			skippedStart = i.Children[len(i.Children) - 1].End
+		}
+		result = append(result, &payload.General{
+			Start:   skippedStart,
+			End:     footerStart - 1,
+			Id:      "ifd",
+			TheData: template.HTML(""),
+			Text:    template.HTML(fmt.Sprintf("<span class=\"not_loaded\">%v further fields, %v further bytes not loaded</span>", i.NotLoaded, footerStart - skippedStart)),
+		})
 	}
 
 	// ifd footer
@@ -148,7 +355,14 @@ func (i *IFD) Render() ([]payload.Section, error) {
 
 func (i *IFD) renderHeader() (payload.Section, error) {
 
-	desc, err := payload.RenderTemplate(ifdHeaderTemplate, i, template.FuncMap{})
+	desc, err := payload.RenderTemplate(ifdHeaderTemplate, i, template.FuncMap{
+		"ParentFieldName": func() string {
+			return fieldName(i.Parent, i.ParentField.ID)
+		},
+		"ParentFieldLink": func() int64 {
+			return i.ParentField.linkTarget()
+		},
+	})
 	if err != nil {
 		return nil, fmt.Errorf("could not render ifd description, %v", err)
 	}
@@ -158,13 +372,16 @@ func (i *IFD) renderHeader() (payload.Section, error) {
 
 	return &payload.General{
 		Start:   i.Start,
-		End:     i.Start + 1,
+		End:     i.Start + int64(len(i.HeaderData)) - 1,
 		Id:      "ifd",
 		TheData: template.HTML(data.String()),
 		Text:    template.HTML(desc),
 	}, nil
 }
-const ifdHeaderTemplate = `The start of an IFD (Image File Directory) that contains <span class="ifd_header">{{.Count}}</span> fields`
+const ifdHeaderTemplate = `The start of <span class="ifd_kind">{{ .Name }}</span>, an IFD (Image File Directory)
+{{ if .Parent }} pointed to by <a href="#{{ ParentFieldLink }}">{{ ParentFieldName }}</a> in <a href="#{{ .Parent.Start }}">{{ .Parent.Name }}</a>,{{ end }}
+{{ if .Previous }} following on from <a href="#{{ .Previous.Start }}">{{ .Previous.Name }}</a>,{{ end }}
+that contains <span class="ifd_header">{{.Count}}</span> fields`
 
 
 func (i *IFD) renderFooter() (payload.Section, error) {
@@ -175,12 +392,15 @@ func (i *IFD) renderFooter() (payload.Section, error) {
 		desc = "This is the last IFD in the chain. If this <span class=\"ifd_footer\">0</span> was a number it would point to the next ifd"
 	} else {
 		desc = fmt.Sprintf("The next ifd can be found at offset <a href=\"#%v\"><span class=\"ifd_footer\">%v</span></a>", i.Next, i.Next)
+		if i.NextPastEnd > 0 {
+			desc += fmt.Sprintf(" <span class=\"past_end\">which is past the end of the file by %v bytes</span>", i.NextPastEnd)
+		}
 	}
 
 	payload.RenderBytesSpan(&data, i.FooterData, "ifd_footer")
 
 	return &payload.General{
-		Start:   i.End - 5,
+		Start:   i.End - int64(len(i.FooterData)),
 		End:     i.End - 1,
 		Id:      "ifd",
 		TheData: template.HTML(data.String()),
@@ -0,0 +1,476 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/emilyselwood/tiffhax/payload"
+	"html/template"
+	"strings"
+)
+
+/*
+JPEGStream is a JPEG stream split into its marker segments. New style JPEG in TIFF (compression 7) stores each strip or
+tile as an abbreviated JPEG stream, with the quantization and huffman tables they share kept in the JPEGTables field
+(347), which is a JPEG stream of its own holding only tables.
+*/
+type JPEGStream struct {
+	Segments []JPEGSegment
+	// Frame describes the start of frame segment, empty when there is not one.
+	Frame string
+	// Quantization and Huffman are the tables the stream defines, huffman tables by class (DC then AC) and id.
+	Quantization [4]bool
+	Huffman      [2][4]bool
+	// UsesQuantization and UsesHuffman are the tables the frame and scans refer to.
+	UsesQuantization [4]bool
+	UsesHuffman      [2][4]bool
+	// Arithmetic is set when the frame uses arithmetic coding, so does not use huffman tables.
+	Arithmetic bool
+	// After is how many bytes follow the end of image marker.
+	After    int64
+	Problems []string
+}
+
+/*
+JPEGSegment is a marker and the bytes that belong to it, relative to the start of the stream. Entropy coded data is not
+a marker of its own, it follows a start of scan and has a Marker of 0.
+*/
+type JPEGSegment struct {
+	Marker byte
+	Start  int64
+	End    int64
+	// Detail is what was found in the segment, like which tables it defines.
+	Detail string
+}
+
+const (
+	jpegSOI = 0xd8
+	jpegEOI = 0xd9
+	jpegSOS = 0xda
+	jpegDQT = 0xdb
+	jpegDHT = 0xc4
+	jpegDAC = 0xcc
+	jpegDRI = 0xdd
+	jpegCOM = 0xfe
+	jpegTEM = 0x01
+)
+
+// how many segments are listed in a summary before just counting them.
+const jpegListed = 16
+
+var jpegFrameTypes = map[byte]string{
+	0xc0: "baseline DCT",
+	0xc1: "extended sequential DCT",
+	0xc2: "progressive DCT",
+	0xc3: "lossless",
+	0xc5: "differential sequential DCT",
+	0xc6: "differential progressive DCT",
+	0xc7: "differential lossless",
+	0xc9: "extended sequential DCT, arithmetic coded",
+	0xca: "progressive DCT, arithmetic coded",
+	0xcb: "lossless, arithmetic coded",
+	0xcd: "differential sequential DCT, arithmetic coded",
+	0xce: "differential progressive DCT, arithmetic coded",
+	0xcf: "differential lossless, arithmetic coded",
+}
+
+/*
+Name is the short name of the marker, like SOI or DQT.
+*/
+func (s JPEGSegment) Name() string {
+	m := s.Marker
+	switch {
+	case m == 0:
+		return "entropy coded data"
+	case m == jpegSOI:
+		return "SOI"
+	case m == jpegEOI:
+		return "EOI"
+	case m == jpegSOS:
+		return "SOS"
+	case m == jpegDQT:
+		return "DQT"
+	case m == jpegDHT:
+		return "DHT"
+	case m == jpegDAC:
+		return "DAC"
+	case m == jpegDRI:
+		return "DRI"
+	case m == jpegCOM:
+		return "COM"
+	case m >= 0xd0 && m <= 0xd7:
+		return fmt.Sprintf("RST%v", m-0xd0)
+	case m >= 0xe0 && m <= 0xef:
+		return fmt.Sprintf("APP%v", m-0xe0)
+	case jpegFrameTypes[m] != "":
+		return fmt.Sprintf("SOF%v", m-0xc0)
+	}
+	return fmt.Sprintf("marker %02X", m)
+}
+
+/*
+class is the css class used to colour the segment.
+*/
+func (s JPEGSegment) class() string {
+	m := s.Marker
+	switch {
+	case m == 0:
+		return "jpeg_entropy"
+	case m == jpegSOI || m == jpegEOI:
+		return "jpeg_image"
+	case m == jpegDQT || m == jpegDHT || m == jpegDAC || m == jpegDRI:
+		return "jpeg_tables"
+	case m == jpegSOS:
+		return "jpeg_scan"
+	case jpegFrameTypes[m] != "":
+		return "jpeg_frame"
+	case (m >= 0xe0 && m <= 0xef) || m == jpegCOM:
+		return "jpeg_app"
+	}
+	return "jpeg_unknown"
+}
+
+/*
+title describes the segment when hovering over its bytes.
+*/
+func (s JPEGSegment) title() string {
+	title := fmt.Sprintf("%v, %v bytes", s.Name(), s.End-s.Start)
+	if s.Detail != "" {
+		title += ", " + s.Detail
+	}
+	return title
+}
+
+func (j *JPEGStream) problem(format string, args ...interface{}) {
+	j.Problems = append(j.Problems, fmt.Sprintf(format, args...))
+}
+
+/*
+parseJPEG splits a JPEG stream into its segments, noting which tables it defines and uses. Parsing stops at the end of
+image marker or the first thing that is not a marker.
+*/
+func parseJPEG(data []byte) *JPEGStream {
+	result := JPEGStream{}
+	if len(data) < 2 || data[0] != 0xff || data[1] != jpegSOI {
+		result.problem("does not start with a JPEG start of image marker")
+		return &result
+	}
+
+	i := 0
+	ended := false
+	for i < len(data) && !ended {
+		if data[i] != 0xff {
+			result.problem("has %v bytes at byte %v that are not part of a JPEG marker", len(data)-i, i)
+			return &result
+		}
+		// any number of fill bytes can come before a marker
+		start := i
+		for i+1 < len(data) && data[i+1] == 0xff {
+			i++
+		}
+		if i+1 >= len(data) {
+			result.problem("ends part way through a JPEG marker at byte %v", start)
+			return &result
+		}
+		marker := data[i+1]
+		i += 2
+
+		segment := JPEGSegment{Marker: marker, Start: int64(start), End: int64(i)}
+		switch {
+		case marker == 0:
+			result.problem("has a stuffed zero byte at byte %v outside of entropy coded data", start)
+			return &result
+		case marker == jpegSOI && start > 0:
+			result.problem("has a second start of image marker at byte %v", start)
+		case marker == jpegEOI:
+			ended = true
+		case marker == jpegSOI || marker == jpegTEM || (marker >= 0xd0 && marker <= 0xd7):
+			// markers that stand on their own
+		default:
+			if i+2 > len(data) {
+				result.problem("ends before the length of the %v marker at byte %v", segment.Name(), start)
+				return &result
+			}
+			length := int(binary.BigEndian.Uint16(data[i:]))
+			end := i + length
+			if length < 2 || end > len(data) {
+				segment.End = int64(len(data))
+				result.Segments = append(result.Segments, segment)
+				result.problem("has a %v segment at byte %v with a length of %v that runs past the end of the data", segment.Name(), start, length)
+				return &result
+			}
+			segment.End = int64(end)
+			segment.Detail = result.readSegment(marker, data[i+2:end], start)
+			i = end
+		}
+		result.Segments = append(result.Segments, segment)
+
+		if marker == jpegSOS {
+			i = result.readEntropy(data, i)
+		}
+	}
+
+	if !ended {
+		result.problem("has no JPEG end of image marker")
+	}
+	result.After = int64(len(data) - i)
+	return &result
+}
+
+/*
+readSegment reads the body of a marker segment, recording the tables it defines or uses, and returns a description of
+it.
+*/
+func (j *JPEGStream) readSegment(marker byte, body []byte, start int) string {
+	switch {
+	case marker == jpegDQT:
+		var tables []string
+		for p := 0; p < len(body); {
+			precision, id := body[p]>>4, body[p]&0x0f
+			size := 1 + 64*(int(precision)+1)
+			if p+size > len(body) || id > 3 {
+				j.problem("has a DQT segment at byte %v with a table that is invalid or runs past its end", start)
+				break
+			}
+			j.Quantization[id] = true
+			tables = append(tables, fmt.Sprintf("%v (%v bit)", id, 8*(precision+1)))
+			p += size
+		}
+		return "quantization tables " + strings.Join(tables, ", ")
+	case marker == jpegDHT:
+		var tables []string
+		for p := 0; p < len(body); {
+			if p+17 > len(body) {
+				j.problem("has a DHT segment at byte %v with a table that runs past its end", start)
+				break
+			}
+			class, id := body[p]>>4, body[p]&0x0f
+			size := 17
+			for _, count := range body[p+1 : p+17] {
+				size += int(count)
+			}
+			if p+size > len(body) || class > 1 || id > 3 {
+				j.problem("has a DHT segment at byte %v with a table that is invalid or runs past its end", start)
+				break
+			}
+			j.Huffman[class][id] = true
+			tables = append(tables, huffmanName(int(class), int(id)))
+			p += size
+		}
+		return "huffman tables " + strings.Join(tables, ", ")
+	case marker == jpegDRI && len(body) >= 2:
+		return fmt.Sprintf("restart every %v MCUs", binary.BigEndian.Uint16(body))
+	case marker == jpegSOS:
+		if len(body) < 1 || len(body) < 1+2*int(body[0]) {
+			j.problem("has a SOS segment at byte %v that is too short for its components", start)
+			return ""
+		}
+		var tables []string
+		for c := 0; c < int(body[0]); c++ {
+			selectors := body[2+2*c]
+			if selectors>>4 > 3 || selectors&0x0f > 3 {
+				j.problem("has a SOS segment at byte %v that refers to a huffman table past 3", start)
+				continue
+			}
+			j.UsesHuffman[0][selectors>>4] = true
+			j.UsesHuffman[1][selectors&0x0f] = true
+			tables = append(tables, fmt.Sprintf("component %v uses %v and %v", body[1+2*c], huffmanName(0, int(selectors>>4)), huffmanName(1, int(selectors&0x0f))))
+		}
+		return fmt.Sprintf("%v components, %v", body[0], strings.Join(tables, ", "))
+	case jpegFrameTypes[marker] != "":
+		if len(body) < 6 || len(body) < 6+3*int(body[5]) {
+			j.problem("has a %v segment at byte %v that is too short for its components", JPEGSegment{Marker: marker}.Name(), start)
+			return ""
+		}
+		components := int(body[5])
+		var tables []string
+		for c := 0; c < components; c++ {
+			id := body[6+3*c+2]
+			if id > 3 {
+				j.problem("has a %v segment at byte %v that refers to quantization table %v, past 3", JPEGSegment{Marker: marker}.Name(), start, id)
+				continue
+			}
+			j.UsesQuantization[id] = true
+			tables = append(tables, fmt.Sprint(id))
+		}
+		j.Arithmetic = marker >= 0xc9
+		j.Frame = fmt.Sprintf("%v frame of %vx%v with %v components of %v bits", jpegFrameTypes[marker], binary.BigEndian.Uint16(body[3:]), binary.BigEndian.Uint16(body[1:]), components, body[0])
+		return fmt.Sprintf("%v, using quantization tables %v", j.Frame, strings.Join(tables, ", "))
+	}
+	return ""
+}
+
+/*
+readEntropy adds a segment for the entropy coded data after a start of scan, which runs until the next marker that is
+not a restart marker. Inside it 0xFF is always followed by a 0 so it can not be mistaken for a marker.
+*/
+func (j *JPEGStream) readEntropy(data []byte, start int) int {
+	restarts := 0
+	i := start
+	for ; i < len(data); i++ {
+		if data[i] != 0xff || i+1 >= len(data) {
+			continue
+		}
+		next := data[i+1]
+		if next == 0 || next == 0xff {
+			continue
+		}
+		if next >= 0xd0 && next <= 0xd7 {
+			restarts++
+			i++
+			continue
+		}
+		break
+	}
+	if i > start {
+		detail := ""
+		if restarts > 0 {
+			detail = fmt.Sprintf("%v restart markers", restarts)
+		}
+		j.Segments = append(j.Segments, JPEGSegment{Start: int64(start), End: int64(i), Detail: detail})
+	}
+	return i
+}
+
+func huffmanName(class int, id int) string {
+	if class == 0 {
+		return fmt.Sprintf("DC %v", id)
+	}
+	return fmt.Sprintf("AC %v", id)
+}
+
+/*
+annotations marks up the bytes of the stream with a span for each segment.
+*/
+func (j *JPEGStream) annotations() []payload.Annotation {
+	var result []payload.Annotation
+	for _, s := range j.Segments {
+		result = append(result, payload.Annotation{Start: s.Start, End: s.End, Class: s.class(), Title: s.title()})
+	}
+	return result
+}
+
+/*
+summary lists the segments of the stream in order with their sizes.
+*/
+func (j *JPEGStream) summary() string {
+	var parts []string
+	for i, s := range j.Segments {
+		if i == jpegListed {
+			parts = append(parts, fmt.Sprintf("%v more", len(j.Segments)-jpegListed))
+			break
+		}
+		switch s.Marker {
+		case jpegSOI, jpegEOI:
+			parts = append(parts, s.Name())
+		case 0:
+			parts = append(parts, fmt.Sprintf("%v bytes of entropy coded data", s.End-s.Start))
+		default:
+			parts = append(parts, fmt.Sprintf("%v of %v bytes", s.Name(), s.End-s.Start))
+		}
+	}
+	return "JPEG markers " + strings.Join(parts, ", ")
+}
+
+/*
+definedTables lists the tables the stream defines.
+*/
+func (j *JPEGStream) definedTables() string {
+	var tables []string
+	for id, defined := range j.Quantization {
+		if defined {
+			tables = append(tables, fmt.Sprintf("quantization %v", id))
+		}
+	}
+	for class := range j.Huffman {
+		for id, defined := range j.Huffman[class] {
+			if defined {
+				tables = append(tables, "huffman "+huffmanName(class, id))
+			}
+		}
+	}
+	return strings.Join(tables, ", ")
+}
+
+/*
+checkTables finds the tables a strip or tile uses and works out where they come from, the stream itself or the
+JPEGTables field. tables is nil when there is no JPEGTables field.
+*/
+func (j *JPEGStream) checkTables(tables *JPEGStream, tablesStart int64, s *decodeState) {
+	var shared, missing []string
+	check := func(name string, used bool, here bool, inTables bool) {
+		switch {
+		case !used || here:
+		case inTables:
+			shared = append(shared, name)
+		default:
+			missing = append(missing, name)
+		}
+	}
+	for id := range j.UsesQuantization {
+		check(fmt.Sprintf("quantization table %v", id), j.UsesQuantization[id], j.Quantization[id], tables != nil && tables.Quantization[id])
+	}
+	if !j.Arithmetic {
+		for class := range j.UsesHuffman {
+			for id := range j.UsesHuffman[class] {
+				check("huffman table "+huffmanName(class, id), j.UsesHuffman[class][id], j.Huffman[class][id], tables != nil && tables.Huffman[class][id])
+			}
+		}
+	}
+
+	if len(shared) > 0 {
+		s.note("%v come from the JPEGTables at byte %v", strings.Join(shared, ", "), tablesStart)
+	}
+	for _, name := range missing {
+		if tables == nil {
+			s.problem("uses %v which it does not define and there is no JPEGTables field", name)
+		} else {
+			s.problem("uses %v which is not defined by it or the JPEGTables at byte %v", name, tablesStart)
+		}
+	}
+}
+
+/*
+decodeJPEG splits a JPEG compressed block (compression 7) into its markers and checks the tables it uses are defined.
+The image data itself is not decoded.
+*/
+func decodeJPEG(data []byte, s *decodeState) {
+	s.result.SegmentsOnly = true
+	stream := parseJPEG(data)
+	for _, segment := range stream.Segments {
+		s.annotate(segment.Start, segment.End, segment.class(), "%v", segment.title())
+	}
+	s.note("%v", stream.summary())
+	if stream.Frame != "" {
+		s.note("%v", stream.Frame)
+	}
+	if stream.After > 0 {
+		s.note("%v bytes after the end of image marker", stream.After)
+	}
+	s.result.Problems = append(s.result.Problems, stream.Problems...)
+	if stream.Frame == "" && len(stream.Problems) == 0 {
+		s.problem("has no JPEG start of frame marker")
+	}
+	stream.checkTables(s.jpegTables, s.jpegTablesStart, s)
+}
+
+/*
+describeJPEGTables describes the JPEG stream held in a JPEGTables field.
+*/
+func (o *Offset) describeJPEGTables() template.HTML {
+	var desc strings.Builder
+	desc.WriteString(" holding the JPEG tables shared by the strips or tiles of the image<br />")
+	desc.WriteString(template.HTMLEscapeString(o.JPEG.summary()))
+	if tables := o.JPEG.definedTables(); tables != "" {
+		desc.WriteString("<br />Defines ")
+		desc.WriteString(template.HTMLEscapeString(tables))
+	}
+	if o.JPEG.After > 0 {
+		_, _ = fmt.Fprintf(&desc, "<br />%v bytes after the end of image marker", o.JPEG.After)
+	}
+	for _, problem := range o.JPEG.Problems {
+		desc.WriteString("<br /><span class=\"decode_problem\">")
+		desc.WriteString(template.HTMLEscapeString(problem))
+		desc.WriteString("</span>")
+	}
+	return template.HTML(desc.String())
+}
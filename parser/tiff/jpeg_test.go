@@ -0,0 +1,178 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+/*
+jpegTest encodes a small colour image and splits it the way new style JPEG in TIFF does, into a stream of just the
+tables for JPEGTables and an abbreviated stream without them for the strip. keep lists the table markers to leave in
+the tables stream.
+*/
+func jpegTest(t *testing.T, keep ...byte) (full []byte, tables []byte, strip []byte) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 32), B: 128, A: 255})
+		}
+	}
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, nil); err != nil {
+		t.Fatalf("could not encode jpeg: %v", err)
+	}
+	full = out.Bytes()
+
+	tables = []byte{0xff, jpegSOI}
+	strip = []byte{0xff, jpegSOI}
+	i := 2
+	for full[i+1] != jpegSOS {
+		end := i + 2 + int(binary.BigEndian.Uint16(full[i+2:]))
+		if full[i+1] == jpegDQT || full[i+1] == jpegDHT {
+			if bytes.IndexByte(keep, full[i+1]) >= 0 {
+				tables = append(tables, full[i:end]...)
+			}
+		} else {
+			strip = append(strip, full[i:end]...)
+		}
+		i = end
+	}
+	tables = append(tables, 0xff, jpegEOI)
+	strip = append(strip, full[i:]...)
+	return full, tables, strip
+}
+
+func TestParseJPEG(t *testing.T) {
+	full, tables, strip := jpegTest(t, jpegDQT, jpegDHT)
+	_, quantizationOnly, _ := jpegTest(t, jpegDQT)
+
+	tests := []struct {
+		name     string
+		tables   []byte
+		strip    []byte
+		notes    []string
+		problems []string
+	}{
+		{
+			name:   "tables in JPEGTables",
+			tables: tables,
+			strip:  strip,
+			notes: []string{
+				"JPEG markers SOI, SOF0 of 19 bytes, SOS of 14 bytes,",
+				"baseline DCT frame of 16x8 with 3 components of 8 bits",
+				"quantization table 0, quantization table 1, huffman table DC 0, huffman table DC 1, huffman table AC 0, huffman table AC 1 come from the JPEGTables",
+			},
+		},
+		{
+			name:  "tables in the strip",
+			strip: full,
+			notes: []string{"JPEG markers SOI, DQT of 134 bytes, SOF0 of 19 bytes, DHT of 420 bytes, SOS of 14 bytes,"},
+		},
+		{
+			name:   "huffman tables missing",
+			tables: quantizationOnly,
+			strip:  strip,
+			problems: []string{
+				"uses huffman table DC 0 which is not defined by it or the JPEGTables",
+				"uses huffman table DC 1",
+				"uses huffman table AC 0",
+				"uses huffman table AC 1",
+			},
+		},
+		{
+			name:  "no JPEGTables",
+			strip: strip,
+			problems: []string{
+				"uses quantization table 0 which it does not define and there is no JPEGTables field",
+				"uses quantization table 1",
+				"uses huffman table DC 0",
+				"uses huffman table DC 1",
+				"uses huffman table AC 0",
+				"uses huffman table AC 1",
+			},
+		},
+		{
+			name:     "truncated",
+			tables:   tables,
+			strip:    strip[:len(strip)-10],
+			problems: []string{"has no JPEG end of image marker"},
+		},
+		{
+			name:     "not jpeg",
+			tables:   tables,
+			strip:    []byte{1, 2, 3, 4},
+			problems: []string{"does not start with a JPEG start of image marker"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBuilder(binary.LittleEndian, false)
+			start := b.add(test.strip)
+			entries := []testEntry{
+				b.short(256, 16),
+				b.short(257, 8),
+				b.short(258, 8, 8, 8),
+				b.short(259, 7),
+				b.long(273, uint32(start)),
+				b.short(277, 3),
+				b.long(279, uint32(len(test.strip))),
+			}
+			if test.tables != nil {
+				entries = append(entries, testEntry{ID: 347, DType: 7, Count: uint64(len(test.tables)), Value: test.tables})
+			}
+			b.setFirstIFD(b.ifd(entries, 0))
+
+			doc := parseTest(t, b)
+			if len(doc.Data) != 1 || doc.Data[0].Decoding == nil {
+				t.Fatalf("strip was not segmented")
+			}
+			result := doc.Data[0].Decoding
+			if !result.SegmentsOnly || result.Method != "JPEG" {
+				t.Errorf("got %+v expected a segmented JPEG strip", result)
+			}
+			for _, note := range test.notes {
+				if !strings.Contains(strings.Join(result.Notes, "\n"), note) {
+					t.Errorf("notes %v should contain %q", result.Notes, note)
+				}
+			}
+			if len(result.Problems) != len(test.problems) {
+				t.Fatalf("got problems %v expected %v", result.Problems, test.problems)
+			}
+			for i, problem := range test.problems {
+				if !strings.Contains(result.Problems[i], problem) {
+					t.Errorf("problem was %q expected %q", result.Problems[i], problem)
+				}
+			}
+			if len(doc.Diagnostics) != len(test.problems) {
+				t.Errorf("got diagnostics %v expected one for each problem", doc.Diagnostics)
+			}
+			if _, err := RenderHTML(doc); err != nil {
+				t.Errorf("could not render: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseJPEGTables(t *testing.T) {
+	_, tables, _ := jpegTest(t, jpegDQT, jpegDHT)
+	stream := parseJPEG(tables)
+	if len(stream.Problems) != 0 {
+		t.Errorf("got problems %v", stream.Problems)
+	}
+	var classes []string
+	for _, a := range stream.annotations() {
+		classes = append(classes, a.Class)
+	}
+	if strings.Join(classes, " ") != "jpeg_image jpeg_tables jpeg_tables jpeg_image" {
+		t.Errorf("annotated %v", classes)
+	}
+	if defined := stream.definedTables(); defined != "quantization 0, quantization 1, huffman DC 0, huffman DC 1, huffman AC 0, huffman AC 1" {
+		t.Errorf("defines %v", defined)
+	}
+}
@@ -0,0 +1,100 @@
+package tiff
+
+/*
+Limits caps how much of a file the parser will read, so that a file claiming huge counts can not make it allocate far
+more memory than the file could possibly hold. Anything past a limit is skipped and reported as a diagnostic rather
+than read. A limit of zero means there is no limit.
+*/
+type Limits struct {
+	// MaxOffsetBytes is the most bytes read for the values of a single field that did not fit in the field.
+	MaxOffsetBytes int64
+	// MaxIFDs is the most IFDs read, counting sub IFDs.
+	MaxIFDs int
+	// MaxFieldsPerIFD is the most fields read from each IFD.
+	MaxFieldsPerIFD uint64
+	// MaxSections is the most parts of the file (header, IFDs, fields, offsets and data blocks) that are placed.
+	MaxSections int
+	// MaxDecodeBytes is the biggest compressed data block that is decoded, and the most bytes decoded from each block.
+	MaxDecodeBytes int64
+	// MaxTotalDecodeBytes is the most bytes read and decoded across all the blocks of the file, once it is used up the
+	// rest of the blocks are not decoded.
+	MaxTotalDecodeBytes int64
+}
+
+/*
+DefaultLimits are used by Parse. They are far beyond what a sensible tiff file needs while keeping the memory used for
+a hostile one to tens of megabytes.
+*/
+var DefaultLimits = Limits{
+	MaxOffsetBytes:      1 << 20,
+	MaxIFDs:             1000,
+	MaxFieldsPerIFD:     4096,
+	MaxSections:         100000,
+	MaxDecodeBytes:      16 << 20,
+	MaxTotalDecodeBytes: 256 << 20,
+}
+
+/*
+offsetBytes works out how many of size bytes of values should be read, keeping to whole values of valueSize bytes.
+*/
+func (l Limits) offsetBytes(size int64, valueSize int64) int64 {
+	if l.MaxOffsetBytes <= 0 || size <= l.MaxOffsetBytes {
+		return size
+	}
+	if valueSize <= 0 {
+		return l.MaxOffsetBytes
+	}
+	return l.MaxOffsetBytes - l.MaxOffsetBytes%valueSize
+}
+
+/*
+sectionCount keeps track of how many sections have been used against the limit, and what was skipped once it was
+reached so it can be summarised.
+*/
+type sectionCount struct {
+	used         int
+	skipped      int
+	skippedBytes int64
+	firstSkipped int64
+	// pending is how many data blocks have been found but not placed yet, they take a section each once placed.
+	pending int
+}
+
+/*
+reserve takes n sections for a part of the file starting at start that is size bytes long. It is false when there are
+not enough sections left, in which case the part should be skipped.
+*/
+func (doc *Document) reserve(n int, start int64, size int64) bool {
+	if doc.Limits.MaxSections <= 0 || doc.sections.used+n <= doc.Limits.MaxSections {
+		doc.sections.used += n
+		return true
+	}
+	doc.skip(1, start, size)
+	return false
+}
+
+/*
+skip records n parts of the file starting at start, size bytes long in total, that were skipped because of the limit.
+*/
+func (doc *Document) skip(n int, start int64, size int64) {
+	if doc.sections.skipped == 0 {
+		doc.sections.firstSkipped = start
+	}
+	doc.sections.skipped += n
+	doc.sections.skippedBytes += size
+}
+
+/*
+dataBudget is how many more data blocks can be found without going over the limit once they are placed, counting the
+ones already found. It is negative when there is no limit.
+*/
+func (doc *Document) dataBudget() int {
+	if doc.Limits.MaxSections <= 0 {
+		return -1
+	}
+	left := doc.Limits.MaxSections - doc.sections.used - doc.sections.pending
+	if left < 0 {
+		return 0
+	}
+	return left
+}
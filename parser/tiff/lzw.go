@@ -0,0 +1,164 @@
+package tiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwFirst    = 258
+	lzwMaxCodes = 4096
+	// how many positions of each kind of code to list before just counting them.
+	lzwListed = 8
+)
+
+/*
+decodeLZW decodes the TIFF flavour of LZW (compression 5). Codes are packed most significant bit first, start at 9 bits
+and grow a code early, when the table reaches 511, 1023 and 2047 entries, up to 12 bits. Code 256 clears the table and
+257 marks the end of the data.
+*/
+func decodeLZW(data []byte, s *decodeState) {
+	if len(data) >= 2 && data[0] == 0 && data[1]&1 == 1 {
+		s.problem("looks like the old style of LZW with codes packed least significant bit first, which is not decoded")
+		return
+	}
+
+	var prefix [lzwMaxCodes]uint16
+	var suffix [lzwMaxCodes]byte
+	var length [lzwMaxCodes]uint16
+	for i := 0; i < 256; i++ {
+		suffix[i] = byte(i)
+		length[i] = 1
+	}
+	var scratch [lzwMaxCodes]byte
+	// entry writes out the bytes of a code, which are stored backwards as a chain of prefixes.
+	entry := func(code int) []byte {
+		n := int(length[code])
+		for i := n - 1; i >= 0; i-- {
+			scratch[i] = suffix[code]
+			code = int(prefix[code])
+		}
+		return scratch[:n]
+	}
+
+	var clears, widths []string
+	clearCount, widthCount := 0, 0
+	width := 9
+	next := lzwFirst
+	previous := -1
+	tableFull := false
+	bit := 0
+	for {
+		if bit+width > len(data)*8 {
+			s.problem("has no end of information code before the end of the block")
+			break
+		}
+		code := readBits(data, bit, width)
+		start, end := int64(bit/8), int64((bit+width+7)/8)
+		bit += width
+
+		if code == lzwClear {
+			clearCount++
+			if clearCount <= lzwListed {
+				clears = append(clears, fmt.Sprint(start))
+			}
+			s.annotate(start, end, "lzw_clear", "clear code")
+			width = 9
+			next = lzwFirst
+			previous = -1
+			tableFull = false
+			continue
+		}
+		if code == lzwEOI {
+			s.annotate(start, end, "lzw_eoi", "end of information code")
+			s.note("end of information code at byte %v", start)
+			if after := int64(len(data)) - end; after > 0 {
+				s.note("%v bytes after the end of information code", after)
+			}
+			break
+		}
+
+		if previous < 0 {
+			if clearCount == 0 && start == 0 {
+				s.problem("does not start with a clear code")
+			}
+			if code > 255 {
+				s.annotate(start, end, "lzw_invalid", "invalid code %v", code)
+				s.problem("has an invalid code %v at byte %v straight after a clear code", code, start)
+				break
+			}
+			previous = code
+			if !s.write(byte(code)) {
+				return
+			}
+			continue
+		}
+
+		var out []byte
+		switch {
+		case code < next:
+			out = entry(code)
+		case code == next && !tableFull:
+			out = append(entry(previous), scratch[0])
+		default:
+			s.annotate(start, end, "lzw_invalid", "invalid code %v", code)
+			s.problem("has an invalid code %v at byte %v when the table only has %v entries", code, start, next)
+			s.note("%v", describeLZWCodes(clearCount, clears, widthCount, widths))
+			return
+		}
+
+		if next < lzwMaxCodes {
+			prefix[next] = uint16(previous)
+			suffix[next] = out[0]
+			length[next] = length[previous] + 1
+			next++
+			// early change, the width goes up one code before it is needed
+			if next+1 == 1<<uint(width) && width < 12 {
+				width++
+				widthCount++
+				if widthCount <= lzwListed {
+					widths = append(widths, fmt.Sprintf("%v bits at byte %v", width, end))
+				}
+			}
+		} else if !tableFull {
+			tableFull = true
+			s.problem("fills the code table at byte %v without a clear code", start)
+		}
+		previous = code
+		if !s.write(out...) {
+			return
+		}
+	}
+	s.note("%v", describeLZWCodes(clearCount, clears, widthCount, widths))
+}
+
+/*
+readBits reads a code of width bits starting at bit, most significant bit first.
+*/
+func readBits(data []byte, bit int, width int) int {
+	code := 0
+	for i := 0; i < width; i++ {
+		b := data[(bit+i)/8]
+		code = code<<1 | int(b>>(7-uint((bit+i)%8))&1)
+	}
+	return code
+}
+
+func describeLZWCodes(clearCount int, clears []string, widthCount int, widths []string) string {
+	desc := fmt.Sprintf("%v clear codes", clearCount)
+	if len(clears) > 0 {
+		desc += " at bytes " + strings.Join(clears, ", ")
+		if clearCount > len(clears) {
+			desc += ", ..."
+		}
+	}
+	if widthCount > 0 {
+		desc += fmt.Sprintf(", the code width grew %v times, to %v", widthCount, strings.Join(widths, ", "))
+		if widthCount > len(widths) {
+			desc += ", ..."
+		}
+	}
+	return desc
+}
@@ -10,67 +10,148 @@ import (
 	"html/template"
 	"io"
 	"reflect"
+	"strings"
 )
 
 /*
 Offset holds a link from somewhere in a file to somewhere else.
 */
 type Offset struct {
+	parser.Sharing
+
 	From    int64
 	To      int64
 	Start   int64
 	End     int64
 	DType   uint16
-	Count   uint32
+	Count   uint64
 	FieldId uint16
 	IsData  bool
 	Data    []byte
 	IFD     *IFD
+	// Field is the field that points here.
+	Field   *Field
+	Order   binary.ByteOrder
+	Values  Values
+	// NotLoaded is how many bytes of values at the end were skipped because of the limits, Data only holds the rest.
+	NotLoaded int64
+	// JPEG is the stream of tables held by a JPEGTables field.
+	JPEG    *JPEGStream
 }
 
-
+/*
+Parse reads the values the offset points to using the DefaultLimits.
+*/
 func (o *Offset) Parse(in io.ReadSeeker, order binary.ByteOrder) ([]*Data, error) {
+	return o.parse(in, order, DefaultLimits, -1)
+}
+
+/*
+parse reads the values the offset points to, only reading as many bytes as the limits allow. When the values are the
+positions of image data no more than budget data blocks are returned, a negative budget means there is no limit.
+*/
+func (o *Offset) parse(in io.ReadSeeker, order binary.ByteOrder, limits Limits, budget int) ([]*Data, error) {
 	_, err := in.Seek(o.To, io.SeekStart)
 	if err != nil {
 		return nil, fmt.Errorf("could not seek to offset start %v, %v", o.To, err)
 	}
 
 	o.Start = o.To
+	o.Order = order
 	o.End = o.Start + (int64(o.Count) * int64(constants.DataTypeSize[o.DType]))
-	if !o.IsData {
-		o.Data = make([]byte, o.End - o.Start)
-		n, err := in.Read(o.Data)
-		if err != nil {
-			return nil, fmt.Errorf("could not read data at offset %v, %v", o.To, err)
-		}
-		if int64(n) != o.End - o.Start {
-			return nil, fmt.Errorf("did not get enough data when reading at offset %v", o.To)
-		}
-		return nil, nil
+	loaded := limits.offsetBytes(o.End - o.Start, int64(constants.DataTypeSize[o.DType]))
+	o.NotLoaded = o.End - o.Start - loaded
+	o.Data = make([]byte, loaded)
+	n, err := io.ReadFull(in, o.Data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read data at offset %v, %v", o.To, err)
 	}
-	chunk := make([]byte, constants.DataTypeSize[o.DType])
-	var data []*Data
-	for i := 0; uint32(i) < o.Count; i++ {
-		var d Data
-		n, err := in.Read(chunk)
-		if err != nil {
-			return nil, fmt.Errorf("could not read offset entry, %v", err)
-		}
-		if uint32(n) != constants.DataTypeSize[o.DType] {
-			return nil, fmt.Errorf("got wrong number of bytes from read, expected %v got %v", constants.DataTypeSize[o.DType], n)
-		}
+	if int64(n) != loaded {
+		return nil, fmt.Errorf("did not get enough data when reading at offset %v", o.To)
+	}
+	o.Values = DecodeValues(o.Data, o.DType, order)
+	if o.FieldId == 347 {
+		o.JPEG = parseJPEG(o.Data)
+	}
+	return o.dataBlocks(budget), nil
+}
 
-		o.Data = append(o.Data, chunk...)
+/*
+reuse takes the values of another offset to the same place that has already been read rather than reading them again.
+*/
+func (o *Offset) reuse(read *Offset, budget int) []*Data {
+	o.Start = read.Start
+	o.End = read.End
+	o.Order = read.Order
+	o.NotLoaded = read.NotLoaded
+	o.Data = read.Data
+	o.Values = read.Values
+	o.JPEG = read.JPEG
+	return o.dataBlocks(budget)
+}
 
-		d.Start = int64(order.Uint32(chunk))
-		d.IFD = o.IFD
-		d.I = i
-		data = append(data, &d)
+/*
+dataBlocks returns a data block for each of the first budget entries of an offset to the positions of image data, or
+all of them when budget is negative.
+*/
+func (o *Offset) dataBlocks(budget int) []*Data {
+	size := int(constants.DataTypeSize[o.DType])
+	if !o.IsData || size == 0 {
+		return nil
+	}
+	var data []*Data
+	for i := 0; i+size <= len(o.Data) && (budget < 0 || len(data) < budget); i += size {
+		data = append(data, &Data{
+			Start:  int64(ReadBuffer(o.Data[i:i+size], o.Order)),
+			IFD:    o.IFD,
+			I:      i / size,
+			IsTile: o.FieldId == 324,
+		})
 	}
+	return data
+}
 
-	return data, nil
+/*
+entries is how many values of the offset were read.
+*/
+func (o *Offset) entries() int {
+	size := int(constants.DataTypeSize[o.DType])
+	if size == 0 {
+		return 0
+	}
+	return len(o.Data) / size
 }
 
+/*
+isSubIFDs says if this is an array of SubIFDs offsets that need to be followed.
+*/
+func (o *Offset) isSubIFDs() bool {
+	return o.FieldId == 330 && o.IFD != nil && o.IFD.Kind == ImageIFD
+}
+
+/*
+subIFDOffsets decodes the entries of a SubIFDs array into the offsets of the IFDs they point to.
+*/
+func (o *Offset) subIFDOffsets(order binary.ByteOrder) []int64 {
+	size := int(constants.DataTypeSize[o.DType])
+	if size == 0 {
+		return nil
+	}
+	var result []int64
+	for i := 0; i+size <= len(o.Data); i += size {
+		if offset := ReadBuffer(o.Data[i:i+size], order); offset != 0 {
+			result = append(result, int64(offset))
+		}
+	}
+	return result
+}
+
+/*
+Range returns the start of the values the offset points to and one past its end.
+*/
+func (o *Offset) Range() (int64, int64) {
+	return o.Start, o.End
+}
 
 func (o *Offset) Contains(offset int64) bool {
 	return o.Start <= offset && offset < o.End
@@ -91,19 +172,32 @@ func (o *Offset) Split(start int64, end int64, newBit parser.Region) error {
 	return fmt.Errorf("Offset between %v and %v can not be split between %v and %v to insert a %v", o.Start, o.End, start, end, reflect.TypeOf(newBit))
 }
 
-func (o *Offset) Render() ([]payload.Section, error) {
+func (o *Offset) render() ([]payload.Section, error) {
+	if o.FieldId == 34735 && len(o.Data) >= 8 {
+		return o.renderGeoKeyDirectory()
+	}
 
 	desc, err := payload.RenderTemplate(offsetTemplate, o, template.FuncMap{
 		"FieldNames": func(fieldId uint16) string {
-			return constants.FieldNames[fieldId]
+			return fieldName(o.IFD, fieldId)
 		},
 		"DataTypeNames": func(typeId uint16) string {
 			return constants.DataTypeNames[typeId]
 		},
-		"FieldValueLookUp" : func() string {
+		"FieldValueLookUp" : func() template.HTML {
 			if o.DType == 2 {
-				value := string(o.Data)
-				return " which decodes to \"" + value + "\""
+				// ascii values end with a null that is not part of the text
+				value := strings.TrimRight(string(o.Data), "\x00")
+				return template.HTML(" which decodes to \"" + template.HTMLEscapeString(value) + "\"")
+			}
+			if o.JPEG != nil {
+				return o.describeJPEGTables()
+			}
+			if desc, ok := o.describeGeoreferencing(); ok {
+				return desc
+			}
+			if o.Values.Len() > 0 {
+				return " which decode to " + renderValues(o.Values)
 			}
 			return ""
 		},
@@ -114,7 +208,18 @@ func (o *Offset) Render() ([]payload.Section, error) {
 
 	var dataBuffer bytes.Buffer
 	if len(o.Data) > 0 {
-		payload.RenderByteBlocks(&dataBuffer, o.Data, int(constants.DataTypeSize[o.DType]), []string {"offset_a", "offset_b", "offset_c"})
+		if o.FieldId == 34736 || o.FieldId == 34737 {
+			// geo keys can point to individual values in these so give them anchors to link to.
+			payload.RenderAnchoredByteBlocks(&dataBuffer, o.Data, int(constants.DataTypeSize[o.DType]), []string {"offset_a", "offset_b", "offset_c"}, o.Start)
+		} else if o.JPEG != nil {
+			dataBuffer.WriteString(payload.RenderAnnotatedBytes(o.Data, 0, o.JPEG.annotations()))
+		} else if isRational(o.DType) {
+			payload.RenderByteBlocks(&dataBuffer, o.Data, 4, rationalClasses)
+		} else if size, ok := o.georeferenceBlockSize(); ok {
+			payload.RenderByteBlocks(&dataBuffer, o.Data, size, []string {"offset_a", "offset_b"})
+		} else {
+			payload.RenderByteBlocks(&dataBuffer, o.Data, int(constants.DataTypeSize[o.DType]), []string {"offset_a", "offset_b", "offset_c"})
+		}
 	}
 
 	return []payload.Section{
@@ -128,4 +233,4 @@ func (o *Offset) Render() ([]payload.Section, error) {
 	}, nil
 }
 
-const offsetTemplate = `{{.Count}} {{DataTypeNames .DType}} values for <a href="#{{.From}}">{{FieldNames .FieldId}}</a> {{ FieldValueLookUp }}`
\ No newline at end of file
+const offsetTemplate = `{{.Count}} {{DataTypeNames .DType}} values for <a href="#{{.From}}">{{FieldNames .FieldId}}</a> {{ FieldValueLookUp }}{{if .NotLoaded}}<br /><span class="not_loaded">{{.NotLoaded}} further bytes not loaded</span>{{end}}`
\ No newline at end of file
@@ -0,0 +1,60 @@
+package tiff
+
+/*
+decodePackBits decodes the PackBits run length encoding (compression 32773). Each run starts with a header byte n. When
+n is 0 to 127 the next n+1 bytes are copied as they are, when it is -1 to -127 the next byte is repeated 1-n times and
+-128 does nothing.
+*/
+func decodePackBits(data []byte, s *decodeState) {
+	var literals, repeats int
+	expected := s.result.Expected
+	i := 0
+	for i < len(data) {
+		n := int8(data[i])
+		start := int64(i)
+
+		var run []byte
+		switch {
+		case n >= 0:
+			count := int(n) + 1
+			end := i + 1 + count
+			s.annotate(start, start+1, "packbits_literal_header", "literal run of %v bytes", count)
+			if end > len(data) {
+				s.problem("has a literal run of %v bytes at byte %v that runs %v bytes past the end of the block", count, i, end-len(data))
+				end = len(data)
+			}
+			s.annotate(start+1, int64(end), "packbits_literal", "%v literal bytes", end-i-1)
+			run = data[i+1 : end]
+			literals++
+			i = end
+		case n == -128:
+			s.annotate(start, start+1, "packbits_noop", "no operation")
+			i++
+			continue
+		default:
+			count := 1 - int(n)
+			if i+1 >= len(data) {
+				s.annotate(start, start+1, "packbits_run", "repeat run of %v bytes", count)
+				s.problem("has a repeat run at byte %v that is missing the byte to repeat", i)
+				i++
+				continue
+			}
+			s.annotate(start, start+2, "packbits_run", "%02X repeated %v times", data[i+1], count)
+			run = make([]byte, count)
+			for j := range run {
+				run[j] = data[i+1]
+			}
+			repeats++
+			i += 2
+		}
+
+		if before := s.result.Decoded; expected >= 0 && before <= expected && before+int64(len(run)) > expected {
+			s.problem("has a run at byte %v that goes %v bytes past the end of the image data", start, before+int64(len(run))-expected)
+			s.overrun = true
+		}
+		if !s.write(run...) {
+			return
+		}
+	}
+	s.note("%v literal runs and %v repeat runs in %v bytes", literals, repeats, len(data))
+}
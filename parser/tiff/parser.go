@@ -3,11 +3,48 @@ package tiff
 import (
 	"fmt"
 	"github.com/emilyselwood/tiffhax/parser"
+	"github.com/emilyselwood/tiffhax/parser/tiff/constants"
 	"github.com/emilyselwood/tiffhax/payload"
 	"io"
+	"math"
 )
 
+/*
+ParseFile parses a tiff file and renders it as html sections. If parsing fails part way through everything found up to
+that point is rendered along with the error. Use Parse to get at the diagnostics.
+*/
 func ParseFile(in io.ReadSeeker) ([]payload.Section, error) {
+	doc, err := Parse(in)
+	if doc == nil {
+		return nil, err
+	}
+
+	sections, renderErr := RenderHTML(doc)
+	if renderErr != nil {
+		if err != nil {
+			return sections, fmt.Errorf("%v, additionaly while rendering the output the following happend: %v", err, renderErr)
+		}
+		return sections, renderErr
+	}
+	return sections, err
+}
+
+/*
+Parse reads a tiff file into a Document. Problems that only affect part of the file are added to the diagnostics of the
+document and parsing carries on with the rest of it. An error is only returned when nothing more can be read, in which
+case the document holds everything found up to that point.
+
+The DefaultLimits are used, see ParseWithLimits to change them.
+*/
+func Parse(in io.ReadSeeker) (*Document, error) {
+	return ParseWithLimits(in, DefaultLimits)
+}
+
+/*
+ParseWithLimits reads a tiff file into a Document like Parse, reading no more than the limits allow. Anything skipped
+because of them is reported in the diagnostics.
+*/
+func ParseWithLimits(in io.ReadSeeker, limits Limits) (*Document, error) {
 
 	start, end, err := findExtents(in)
 	if err != nil {
@@ -19,54 +56,154 @@ func ParseFile(in io.ReadSeeker) ([]payload.Section, error) {
 		End:      end,
 		Children: []parser.Region{},
 	}
+	result := Document{Root: &startRegion, Limits: limits}
 
 	// start by parsing the header
-	header, l, err := ParseHeader(in)
+	header, _, err := ParseHeader(in)
 	if err != nil {
-		return returnError(&startRegion, fmt.Errorf("could not parse header, %v", err))
+		err = fmt.Errorf("could not parse header, %v", err)
+		result.addDiagnostic(Error, 0, nil, err.Error())
+		result.classifyGaps(in)
+		return &result, err
 	}
-	if err := insert(&startRegion, header, 0, l); err != nil {
-		return returnError(&startRegion, fmt.Errorf("could not insert header %v", err))
+	result.reserve(1, header.Start, header.End-header.Start)
+	if err := parser.Insert(&startRegion, header); err != nil {
+		err = fmt.Errorf("could not insert header %v", err)
+		result.addDiagnostic(Error, 0, nil, err.Error())
+		return &result, err
 	}
+	result.Header = header
 
-	var offsets []*Offset
+	var topLevel []*IFD
 	var data []*Data
-	// start with the first IFD (there must be at least one)
-	ifd, offset, d, err := readIFD(in, header, header.FirstIFDOffset)
-	if err != nil {
-		return returnError(&startRegion, fmt.Errorf("could not read first ifd, %v", err))
-	}
+	// IFDs already read by their offset, so a chain or sub IFD pointing back at one does not loop forever.
+	visited := map[int64]*IFD{}
+	// values already read, so ones shared by several fields are only read once.
+	read := map[offsetKey]*Offset{}
+	// start with the first IFD (there must be at least one) and then walk the tree of chains and sub IFDs.
+	pending := []pendingIFD{{Offset: header.FirstIFDOffset}}
+	for len(pending) > 0 {
+		p := pending[0]
+		pending = pending[1:]
 
-	if err := insert(&startRegion, ifd, ifd.Start, ifd.End); err != nil {
-		return returnError(&startRegion, fmt.Errorf("could not insert ifd, %v", err))
-	}
-	offsets = append(offsets, offset...)
-	data = append(data, d...)
+		if limits.MaxIFDs > 0 && len(result.IFDs) >= limits.MaxIFDs {
+			result.addDiagnostic(Warning, p.Offset, p.referrer(header), "the limit of %v IFDs was reached, %v and %v further IFDs it leads to were not read", limits.MaxIFDs, p.describeReferrer(), len(pending))
+			break
+		}
 
-	for ifd.Next != 0 {
-		ifd, offset, d, err = readIFD(in, header, int64(ifd.Next))
-		if err != nil {
-			return returnError(&startRegion, fmt.Errorf("could not read ifd, %v", err))
+		if over := result.pastEnd(p.Offset, uint64(header.ifdCountSize())); over > 0 {
+			p.markPastEnd(header, over)
+			result.addDiagnostic(Error, p.Offset, p.referrer(header), "%v points past the end of the file by %v bytes", p.describeReferrer(), over)
+			continue
+		}
+		if target, ok := visited[p.Offset]; ok {
+			result.addDiagnostic(Error, p.Offset, p.referrer(header), "%v points back at %v which has already been read, stopping here to avoid a loop", p.describeReferrer(), target.Name())
+			continue
 		}
 
-		if err := insert(&startRegion, ifd, ifd.Start, ifd.End); err != nil {
-			return returnError(&startRegion, fmt.Errorf("could not insert ifd, %v", err))
+		fields, size, err := ifdSize(in, header, p.Offset)
+		if err != nil {
+			result.addDiagnostic(Error, p.Offset, p.referrer(header), "could not read ifd, %v", err)
+			continue
+		}
+		if over := result.pastEnd(p.Offset, size); over > 0 {
+			p.markPastEnd(header, over)
+			result.addDiagnostic(Error, p.Offset, p.referrer(header), "%v points to an IFD of %v fields that runs past the end of the file by %v bytes", p.describeReferrer(), fields, over)
+			continue
 		}
-		offsets = append(offsets, offset...)
-		data = append(data, d...)
-	}
 
-	// now handle the offsets
-	// because an offset can point to a list of offsets we need to keep handling them till we are done.
-	for _, o := range offsets {
-		d, err := o.Parse(in, header.Endian)
+		ifd, offsets, d, err := readIFD(in, header, p.Offset, limits)
 		if err != nil {
-			return returnError(&startRegion, fmt.Errorf("could not parse offset, %v", err))
+			result.addDiagnostic(Error, p.Offset, p.referrer(header), "could not read ifd, %v", err)
+			continue
+		}
+		if !result.reserve(len(ifd.Children)+2, ifd.Start, ifd.End-ifd.Start) {
+			continue
+		}
+		ifd.Kind = p.Kind
+		ifd.Parent = p.Parent
+		ifd.ParentField = p.ParentField
+		ifd.Previous = p.Previous
+
+		if !result.place(ifd, p.Offset, p.referrer(header)) {
+			continue
+		}
+		visited[ifd.Start] = ifd
+		if ifd.NotLoaded > 0 {
+			result.addDiagnostic(Warning, ifd.Start, ifd, "%v has %v fields, only the first %v were read, %v further fields not loaded", ifd.Name(), ifd.Count, len(ifd.Children), ifd.NotLoaded)
 		}
-		if err := insert(&startRegion, o, o.Start, o.End); err != nil {
-			return returnError(&startRegion, fmt.Errorf("could not insert offset result, %v", err))
+		if ifd.Parent == nil {
+			ifd.Index = len(topLevel)
+			topLevel = append(topLevel, ifd)
+		} else {
+			ifd.Index = ifd.Parent.countSubIFDs(ifd.Kind)
+			ifd.Parent.SubIFDs = append(ifd.Parent.SubIFDs, ifd)
 		}
+		result.IFDs = append(result.IFDs, ifd)
 		data = append(data, d...)
+		result.sections.pending += len(d)
+
+		for _, f := range ifd.subIFDs() {
+			kind, _ := f.subIFDKind()
+			for _, offset := range f.subIFDOffsets() {
+				pending = append(pending, pendingIFD{Offset: offset, Kind: kind, Parent: ifd, ParentField: f})
+			}
+		}
+
+		// now handle the offsets
+		// because an offset can point to a list of offsets we need to keep handling them till we are done.
+		for _, o := range offsets {
+			size := multiplySaturating(o.Count, uint64(constants.DataTypeSize[o.DType]))
+			if over := result.pastEnd(o.To, size); over > 0 {
+				o.Field.markPastEnd(over)
+				result.addDiagnostic(Error, o.To, o.Field, "the values of %v point past the end of the file by %v bytes", o.Field.Name(), over)
+				continue
+			}
+			if !result.reserve(1, o.To, int64(size)) {
+				continue
+			}
+			key := offsetKey{to: o.To, size: size, dType: o.DType, fieldId: o.FieldId}
+			budget := result.dataBudget()
+			var d []*Data
+			previous, reused := read[key]
+			if reused {
+				d = o.reuse(previous, budget)
+			} else {
+				d, err = o.parse(in, header.Endian, limits, budget)
+				if err != nil {
+					result.addDiagnostic(Error, o.To, o.Field, "could not parse offset for %v, %v", o.Field.Name(), err)
+					continue
+				}
+				read[key] = o
+			}
+			if skipped := o.entries() - len(d); o.IsData && skipped > 0 {
+				result.skip(skipped, o.To, 0)
+			}
+			result.sections.pending += len(d)
+			if o.NotLoaded > 0 {
+				result.addDiagnostic(Warning, o.To, o.Field, "only the first %v bytes of the values of %v were read, %v further bytes not loaded", len(o.Data), o.Field.Name(), o.NotLoaded)
+			}
+			if !result.place(o, o.Start, o.Field) {
+				continue
+			}
+			result.Offsets = append(result.Offsets, o)
+			data = append(data, d...)
+			if o.JPEG != nil && !reused {
+				for _, problem := range o.JPEG.Problems {
+					result.addDiagnostic(Warning, o.Start, o, "%v %v", describeRegion(o), problem)
+				}
+			}
+
+			if o.isSubIFDs() {
+				for _, sub := range o.subIFDOffsets(header.Endian) {
+					pending = append(pending, pendingIFD{Offset: sub, Kind: ImageIFD, Parent: ifd, ParentField: o.Field})
+				}
+			}
+		}
+
+		if ifd.Next != 0 {
+			pending = append(pending, pendingIFD{Offset: int64(ifd.Next), Kind: ifd.Kind, Parent: ifd.Parent, ParentField: ifd.ParentField, Previous: ifd})
+		}
 	}
 
 	// Finally we need to handle the data sections.
@@ -74,35 +211,128 @@ func ParseFile(in io.ReadSeeker) ([]payload.Section, error) {
 	//  a: where the strips start
 	//  b: how big each strip is.
 
+	decodes := newDecodeCache()
+	// decodings shared by blocks covering the same bytes only have their problems reported once
+	reported := map[*Decoding]bool{}
 	for _, d := range data {
+		result.sections.pending--
 		err := d.Parse(in, header.Endian)
 		if err != nil {
-			return returnError(&startRegion, fmt.Errorf("could not parse data information, %v", err))
+			result.addDiagnostic(Error, d.Start, d.referrer(), "could not parse data information, %v", err)
+			continue
+		}
+		if !d.Located {
+			result.addDiagnostic(Warning, d.Start, d.referrer(), "could not work out where %v is in the image, %v", describeRegion(d), d.positionErr)
 		}
-		if err := insert(&startRegion, d, d.Start, d.End); err != nil {
-			return returnError(&startRegion, fmt.Errorf("could not insert data result, %v", err))
+		if over := result.pastEnd(d.Start, uint64(d.End-d.Start)); over > 0 {
+			if field, ok := d.referrer().(*Field); ok {
+				field.markPastEnd(over)
+			}
+			if d.Start < 0 || d.Start >= result.Root.End {
+				result.addDiagnostic(Error, d.Start, d.referrer(), "%v starts past the end of the file", describeRegion(d))
+				continue
+			}
+			d.Missing = over
+			d.End = result.Root.End
+			result.addDiagnostic(Warning, d.Start, d.referrer(), "%v runs past the end of the file by %v bytes", describeRegion(d), over)
 		}
+		if !result.reserve(1, d.Start, d.End-d.Start) {
+			continue
+		}
+		// sparse tiles and strips have no bytes so there is nothing to place
+		if d.End > d.Start && !result.place(d, d.Start, d.referrer()) {
+			continue
+		}
+		if err := d.readPreview(in); err != nil {
+			result.addDiagnostic(Warning, d.Start, d.referrer(), "could not read a preview of %v, %v", describeRegion(d), err)
+		}
+		if err := d.decode(in, header.Endian, limits, decodes); err != nil {
+			result.addDiagnostic(Warning, d.Start, d, "could not decode %v, %v", describeRegion(d), err)
+		} else if d.Decoding != nil && !reported[d.Decoding] {
+			reported[d.Decoding] = true
+			for _, problem := range d.Decoding.Problems {
+				result.addDiagnostic(Warning, d.Start, d, "%v %v", describeRegion(d), problem)
+			}
+		}
+		result.Data = append(result.Data, d)
 	}
 
+	if result.sections.skipped > 0 {
+		result.addDiagnostic(Warning, result.sections.firstSkipped, nil, "the limit of %v sections was reached, %v further parts of the file were skipped, %v further bytes not loaded", limits.MaxSections, result.sections.skipped, result.sections.skippedBytes)
+	}
+
+	result.classifyGaps(in)
 
-	return startRegion.Render()
+	return &result, nil
 }
 
-func returnError(startRegion parser.Region, inErr error) ([]payload.Section, error) {
-	res, err := startRegion.Render();
-	if err != nil {
-		return res, fmt.Errorf("%v, additionaly while rendering the output the following happend: %v", inErr, err)
+/*
+pendingIFD is an IFD we know about but have not read yet.
+*/
+type pendingIFD struct {
+	Offset      int64
+	Kind        IFDKind
+	Parent      *IFD
+	ParentField *Field
+	Previous    *IFD
+}
+
+/*
+offsetKey identifies values that have been read, the same bytes read as the same type for the same kind of field.
+*/
+type offsetKey struct {
+	to      int64
+	size    uint64
+	dType   uint16
+	fieldId uint16
+}
+
+/*
+referrer is the part of the file that pointed at this IFD, used to link to it when the IFD can not be read.
+*/
+func (p pendingIFD) referrer(header *Header) Node {
+	if p.Previous != nil {
+		return p.Previous
+	}
+	if p.ParentField != nil {
+		return p.ParentField
 	}
-	return res, inErr
+	return header
 }
 
-func readIFD(in io.ReadSeeker, header *Header, offset int64) (*IFD, []*Offset, []*Data, error) {
+/*
+markPastEnd notes on whatever pointed at this IFD that it points past the end of the file.
+*/
+func (p pendingIFD) markPastEnd(header *Header, over uint64) {
+	if p.Previous != nil {
+		p.Previous.NextPastEnd = over
+	} else if p.ParentField != nil {
+		p.ParentField.markPastEnd(over)
+	} else {
+		header.FirstIFDPastEnd = over
+	}
+}
+
+/*
+describeReferrer names the part of the file that pointed at this IFD for use in messages.
+*/
+func (p pendingIFD) describeReferrer() string {
+	if p.Previous != nil {
+		return fmt.Sprintf("the next IFD pointer of %v", p.Previous.Name())
+	}
+	if p.ParentField != nil {
+		return fmt.Sprintf("the %v field of %v", p.ParentField.Name(), p.Parent.Name())
+	}
+	return "the first IFD offset in the header"
+}
+
+func readIFD(in io.ReadSeeker, header *Header, offset int64, limits Limits) (*IFD, []*Offset, []*Data, error) {
 	_, err := in.Seek(offset, io.SeekStart)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("could not seek to IFD, %v", err)
 	}
 
-	ifd, _, offsets, d, err := ParseIFD(in, offset, header.Endian)
+	ifd, _, offsets, d, err := parseIFD(in, offset, header, limits)
 	if err != nil {
 		return  nil, nil, nil, fmt.Errorf("could not parse IFD, %v", err)
 	}
@@ -110,16 +340,73 @@ func readIFD(in io.ReadSeeker, header *Header, offset int64) (*IFD, []*Offset, [
 	return ifd, offsets, d, nil
 }
 
-func insert(top parser.Region, newBit parser.Region, start int64, end int64) error {
-	target, err := top.Find(start)
+/*
+ifdSize reads how many fields the IFD at offset has, to work out how many bytes it takes up.
+*/
+func ifdSize(in io.ReadSeeker, header *Header, offset int64) (uint64, uint64, error) {
+	countData, err := readAt(in, offset, header.ifdCountSize())
 	if err != nil {
-		return fmt.Errorf("could not insert new region, %v", err)
+		return 0, 0, fmt.Errorf("could not read ifd field count, %v", err)
+	}
+	var count uint64
+	if header.BigTiff {
+		count = header.Endian.Uint64(countData)
+	} else {
+		count = uint64(header.Endian.Uint16(countData))
 	}
-	if err := target.Split(start, end, newBit); err != nil {
-		return fmt.Errorf("could not split, %v", err)
+	size := multiplySaturating(count, uint64(header.fieldSize()))
+	rest := uint64(header.ifdCountSize()) + uint64(header.OffsetSize)
+	if size > math.MaxUint64-rest {
+		return count, math.MaxUint64, nil
 	}
+	return count, size + rest, nil
+}
 
-	return nil
+/*
+pastEnd works out how many bytes a region of size bytes starting at start runs past the end of the file, zero when it
+fits. Offsets too big to be held in an int64 come through as negative numbers and are treated as past the end.
+*/
+func (doc *Document) pastEnd(start int64, size uint64) uint64 {
+	fileEnd := uint64(doc.Root.End)
+	if start < 0 {
+		return math.MaxUint64
+	}
+	end := uint64(start) + size
+	if end < uint64(start) {
+		// wrapped around
+		return math.MaxUint64
+	}
+	if end <= fileEnd {
+		return 0
+	}
+	return end - fileEnd
+}
+
+/*
+multiplySaturating multiplies two sizes, giving the largest possible value rather than wrapping around.
+*/
+func multiplySaturating(a uint64, b uint64) uint64 {
+	if b != 0 && a > math.MaxUint64/b {
+		return math.MaxUint64
+	}
+	return a * b
+}
+
+/*
+place inserts a region into the tree, adding a diagnostic when it does not fit. Regions that partly overlap others are
+recorded as conflicts and still count as placed, false is only returned when the region could not be placed at all.
+*/
+func (doc *Document) place(r parser.Region, offset int64, referrer Node) bool {
+	err := parser.Insert(doc.Root, r)
+	if err == nil {
+		return true
+	}
+	if overlap, ok := err.(*parser.OverlapError); ok {
+		doc.addDiagnostic(Warning, offset, referrer, "%v partly overlaps other parts of the file, %v", describeRegion(r), overlap)
+		return true
+	}
+	doc.addDiagnostic(Error, offset, referrer, "could not insert %v, %v", describeRegion(r), err)
+	return false
 }
 
 func findExtents(in io.ReadSeeker) (int64, int64, error) {
@@ -0,0 +1,736 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emilyselwood/tiffhax/parser"
+	"github.com/emilyselwood/tiffhax/payload"
+)
+
+func parseTest(t *testing.T, b *testBuilder) *Document {
+	t.Helper()
+	doc, err := Parse(b.reader())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return doc
+}
+
+func findField(t *testing.T, ifd *IFD, id uint16) *Field {
+	t.Helper()
+	field, err := ifd.FindField(id)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return field
+}
+
+/*
+checkCovered makes sure the nodes of a document run from the start of the file to the end without gaps or overlaps.
+*/
+func checkCovered(t *testing.T, doc *Document, size int64) {
+	t.Helper()
+	var position int64
+	for _, n := range doc.Nodes() {
+		start, end := n.Range()
+		if start != position {
+			t.Errorf("%v starts at %v expected %v", reflect.TypeOf(n), start, position)
+		}
+		position = end
+	}
+	if position != size {
+		t.Errorf("nodes end at %v expected %v", position, size)
+	}
+}
+
+func TestParseStrips(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, big := range []bool{false, true} {
+			b := stripTIFF(order, big)
+			t.Run(order.String()+map[bool]string{false: "", true: " big tiff"}[big], func(t *testing.T) {
+				doc := parseTest(t, b)
+				if len(doc.Diagnostics) != 0 {
+					t.Errorf("unexpected diagnostics: %v", doc.Diagnostics)
+				}
+				checkCovered(t, doc, int64(len(b.bytes())))
+
+				if len(doc.IFDs) != 1 {
+					t.Fatalf("found %v ifds expected 1", len(doc.IFDs))
+				}
+				ifd := doc.IFDs[0]
+				if ifd.Name() != "IFD 0" || ifd.Count != 10 || len(ifd.Children) != 10 {
+					t.Errorf("ifd was %v with %v fields", ifd.Name(), ifd.Count)
+				}
+
+				description := findField(t, ifd, 270)
+				if text, ok := description.Text(); !ok || text != "hello world" {
+					t.Errorf("description was %q", text)
+				}
+				if !description.IsOffset || description.Offset == nil || description.Offset.Field != description {
+					t.Errorf("description should be linked to its offset")
+				}
+
+				counts := findField(t, ifd, 279)
+				if values := counts.DecodedValues().Unsigned; !reflect.DeepEqual(values, []uint64{16, 16}) {
+					t.Errorf("strip byte counts were %v", values)
+				}
+
+				resolution := findField(t, ifd, 282)
+				if resolution.IsOffset == big {
+					t.Errorf("resolution offset was %v, a single rational only fits in a big tiff field", resolution.IsOffset)
+				}
+				if values := resolution.DecodedValues().Rationals; !reflect.DeepEqual(values, []Rational{{72, 1}}) {
+					t.Errorf("resolution was %v", values)
+				}
+
+				if len(doc.Data) != 2 {
+					t.Fatalf("found %v data blocks expected 2", len(doc.Data))
+				}
+				for i, d := range doc.Data {
+					if d.End-d.Start != 16 || d.Y != uint64(i) || d.IsTile {
+						t.Errorf("data block %v was %v to %v at row %v", i, d.Start, d.End, d.Y)
+					}
+					if !bytes.Equal(b.bytes()[d.Start:d.End], bytes.Repeat([]byte{byte(i + 1)}, 16)) {
+						t.Errorf("data block %v is in the wrong place", i)
+					}
+				}
+
+				sections, err := RenderHTML(doc)
+				if err != nil {
+					t.Fatalf("could not render: %v", err)
+				}
+				if len(sections) == 0 {
+					t.Errorf("no sections rendered")
+				}
+			})
+		}
+	}
+}
+
+func TestParseTiles(t *testing.T) {
+	doc := parseTest(t, tiledTIFF(binary.LittleEndian))
+	if len(doc.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", doc.Diagnostics)
+	}
+	if len(doc.Data) != 12 {
+		t.Fatalf("found %v tiles expected 12", len(doc.Data))
+	}
+	for i, d := range doc.Data {
+		expected := [3]uint64{uint64(i % 3), uint64(i/3) % 2, uint64(i / 6)}
+		if actual := [3]uint64{d.X, d.Y, d.Plane}; !d.IsTile || actual != expected {
+			t.Errorf("tile %v was at %v expected %v", i, actual, expected)
+		}
+	}
+}
+
+func TestParseIFDTree(t *testing.T) {
+	b := newTestBuilder(binary.LittleEndian, false)
+	gps := b.ifd([]testEntry{
+		{ID: 0, DType: 1, Count: 4, Value: []byte{2, 3, 0, 0}},
+	}, 0)
+	exif := b.ifd([]testEntry{
+		{ID: 36864, DType: 7, Count: 4, Value: []byte("0230")},
+	}, 0)
+	second := b.ifd([]testEntry{b.short(256, 1)}, 0)
+	first := b.ifd([]testEntry{
+		b.short(256, 1),
+		b.long(34665, uint32(exif)),
+		b.long(34853, uint32(gps)),
+	}, second)
+	b.setFirstIFD(first)
+
+	doc := parseTest(t, b)
+	if len(doc.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", doc.Diagnostics)
+	}
+	checkCovered(t, doc, int64(len(b.bytes())))
+
+	names := map[int64]string{}
+	for _, ifd := range doc.IFDs {
+		names[ifd.Start] = ifd.Name()
+	}
+	expected := map[int64]string{
+		first:  "IFD 0",
+		second: "IFD 1",
+		exif:   "EXIF IFD of IFD 0",
+		gps:    "GPS IFD of IFD 0",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("ifd names were %v expected %v", names, expected)
+	}
+	if len(doc.TopLevelIFDs()) != 2 {
+		t.Errorf("found %v top level ifds expected 2", len(doc.TopLevelIFDs()))
+	}
+
+	for _, ifd := range doc.IFDs {
+		switch ifd.Start {
+		case exif:
+			if name := ifd.Children[0].Name(); name != "ExifVersion" {
+				t.Errorf("exif field was called %v", name)
+			}
+		case gps:
+			if name := ifd.Children[0].Name(); name != "GPSVersionID" {
+				t.Errorf("gps field was called %v", name)
+			}
+		case second:
+			if ifd.Previous == nil || ifd.Previous.Start != first {
+				t.Errorf("second ifd should follow on from the first")
+			}
+		}
+	}
+}
+
+func TestParseInlineSubIFDs(t *testing.T) {
+	// a big tiff has room for two long offsets in the field itself
+	b := newTestBuilder(binary.BigEndian, true)
+	firstSub := b.ifd([]testEntry{b.short(256, 2)}, 0)
+	secondSub := b.ifd([]testEntry{b.short(256, 3)}, 0)
+	b.setFirstIFD(b.ifd([]testEntry{
+		b.short(256, 1),
+		b.long(330, uint32(firstSub), uint32(secondSub)),
+	}, 0))
+
+	doc := parseTest(t, b)
+	if len(doc.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", doc.Diagnostics)
+	}
+	checkCovered(t, doc, int64(len(b.bytes())))
+
+	names := map[int64]string{}
+	for _, ifd := range doc.IFDs {
+		names[ifd.Start] = ifd.Name()
+	}
+	if names[firstSub] != "SubIFD 0 of IFD 0" || names[secondSub] != "SubIFD 1 of IFD 0" {
+		t.Errorf("ifd names were %v", names)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func() *testBuilder
+		severity Severity
+		message  string
+		check    func(t *testing.T, doc *Document)
+	}{
+		{
+			name: "ifd loop",
+			build: func() *testBuilder {
+				b := stripTIFF(binary.LittleEndian, false)
+				b.setNext(b.ifds[0], b.ifds[0])
+				return b
+			},
+			severity: Error,
+			message:  "points back at IFD 0",
+		},
+		{
+			name: "next ifd past the end",
+			build: func() *testBuilder {
+				b := stripTIFF(binary.LittleEndian, false)
+				b.setNext(b.ifds[0], int64(len(b.bytes())+10))
+				return b
+			},
+			severity: Error,
+			message:  "past the end of the file by 12 bytes",
+			check: func(t *testing.T, doc *Document) {
+				if doc.IFDs[0].NextPastEnd != 12 {
+					t.Errorf("next past end was %v", doc.IFDs[0].NextPastEnd)
+				}
+			},
+		},
+		{
+			name: "ifd fields past the end",
+			build: func() *testBuilder {
+				b := stripTIFF(binary.LittleEndian, false)
+				// claims 10 fields but the file ends after the first
+				next := b.add(b.shorts(10))
+				b.buf = append(b.buf, make([]byte, 12)...)
+				b.setNext(b.ifds[0], next)
+				return b
+			},
+			severity: Error,
+			message:  "the next IFD pointer of IFD 0 points to an IFD of 10 fields that runs past the end of the file by 112 bytes",
+			check: func(t *testing.T, doc *Document) {
+				if doc.IFDs[0].NextPastEnd != 112 {
+					t.Errorf("next past end was %v", doc.IFDs[0].NextPastEnd)
+				}
+			},
+		},
+		{
+			name: "values past the end",
+			build: func() *testBuilder {
+				b := stripTIFF(binary.LittleEndian, false)
+				b.setFieldValue(b.ifds[0], 5, uint64(len(b.bytes())-4))
+				return b
+			},
+			severity: Error,
+			message:  "the values of ImageDescription point past the end of the file by 8 bytes",
+			check: func(t *testing.T, doc *Document) {
+				if field := findField(t, doc.IFDs[0], 270); field.PastEnd != 8 {
+					t.Errorf("past end was %v", field.PastEnd)
+				}
+			},
+		},
+		{
+			name: "count that wraps around",
+			build: func() *testBuilder {
+				b := stripTIFF(binary.LittleEndian, true)
+				// 1<<61 rationals of 8 bytes is 1<<64 bytes, which is 0 if it wraps
+				copy(b.buf[b.fieldStart(b.ifds[0], 9)+4:], b.long8s(1<<61))
+				return b
+			},
+			severity: Error,
+			message:  "the values of XResolution point past the end of the file",
+			check: func(t *testing.T, doc *Document) {
+				if field := findField(t, doc.IFDs[0], 282); !field.IsOffset {
+					t.Errorf("field should be an offset")
+				}
+			},
+		},
+		{
+			name: "truncated strip",
+			build: func() *testBuilder {
+				b := stripTIFF(binary.LittleEndian, false)
+				b.buf = append(b.buf, 1, 2, 3, 4)
+				// move the second strip to the last four bytes of the file and make it 20 bytes long
+				offsets := b.order.Uint32(b.bytes()[b.fieldStart(b.ifds[0], 6)+8:])
+				copy(b.buf[offsets+4:], b.longs(uint32(len(b.bytes())-4)))
+				copy(b.buf[b.fieldStart(b.ifds[0], 8)+8:], b.shorts(16, 20))
+				return b
+			},
+			severity: Warning,
+			message:  "runs past the end of the file by 16 bytes",
+			check: func(t *testing.T, doc *Document) {
+				d := doc.Data[1]
+				if d.Missing != 16 || d.End != d.Start+4 {
+					t.Errorf("data block was %v to %v missing %v", d.Start, d.End, d.Missing)
+				}
+			},
+		},
+		{
+			name: "overlapping values",
+			build: func() *testBuilder {
+				b := stripTIFF(binary.LittleEndian, false)
+				// point the resolution into the middle of the description
+				description := b.order.Uint32(b.bytes()[b.fieldStart(b.ifds[0], 5)+8:])
+				b.setFieldValue(b.ifds[0], 9, uint64(description+4))
+				return b
+			},
+			severity: Warning,
+			message:  "the values of XResolution in IFD 0 partly overlaps other parts of the file",
+			check: func(t *testing.T, doc *Document) {
+				field := findField(t, doc.IFDs[0], 270)
+				if conflicts := field.Offset.Shared().Conflicts; len(conflicts) != 1 {
+					t.Errorf("description had %v conflicts expected 1", len(conflicts))
+				}
+				// the resolution still gets a row of its own, straight after the description
+				resolution := findField(t, doc.IFDs[0], 282).Offset
+				sections, err := RenderHTML(doc)
+				if err != nil {
+					t.Fatalf("could not render: %v", err)
+				}
+				found := false
+				for i, s := range sections {
+					general, ok := s.(*payload.General)
+					if !ok || general.Start != field.Offset.Start {
+						continue
+					}
+					found = true
+					if strings.Contains(string(general.Text), "\x00") {
+						t.Errorf("description should not include its null: %q", general.Text)
+					}
+					next, ok := sections[i+1].(*payload.General)
+					if !ok || next.Start != resolution.Start || !strings.Contains(string(next.Text), "region_conflict") {
+						t.Errorf("expected the resolution to follow the description as a conflict, got %+v", sections[i+1])
+					}
+				}
+				if !found {
+					t.Errorf("the description was not rendered")
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := parseTest(t, test.build())
+			if len(doc.Diagnostics) != 1 {
+				t.Fatalf("got %v diagnostics expected 1: %v", len(doc.Diagnostics), doc.Diagnostics)
+			}
+			d := doc.Diagnostics[0]
+			if d.Severity != test.severity || !strings.Contains(d.Message, test.message) {
+				t.Errorf("diagnostic was %v", d)
+			}
+			if d.Node == nil {
+				t.Errorf("diagnostic should say where the problem came from")
+			}
+			if test.check != nil {
+				test.check(t, doc)
+			}
+			if _, err := RenderHTML(doc); err != nil {
+				t.Errorf("could not render: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		message string
+		check   func(t *testing.T, doc *Document)
+		// build makes the file to parse, the stripTIFF when nil
+		build func() *testBuilder
+	}{
+		{
+			name:    "offset bytes",
+			limits:  Limits{MaxOffsetBytes: 8},
+			message: "only the first 8 bytes of the values of ImageDescription were read, 4 further bytes not loaded",
+			check: func(t *testing.T, doc *Document) {
+				o := findField(t, doc.IFDs[0], 270).Offset
+				if string(o.Data) != "hello wo" || o.NotLoaded != 4 || o.End-o.Start != 12 {
+					t.Errorf("loaded %q with %v not loaded between %v and %v", o.Data, o.NotLoaded, o.Start, o.End)
+				}
+			},
+		},
+		{
+			name:    "strip offsets",
+			limits:  Limits{MaxOffsetBytes: 6},
+			message: "only the first 4 bytes of the values of StripOffsets were read, 4 further bytes not loaded",
+			check: func(t *testing.T, doc *Document) {
+				if len(doc.Data) != 1 {
+					t.Errorf("got %v strips expected 1", len(doc.Data))
+				}
+			},
+		},
+		{
+			name:    "fields",
+			limits:  Limits{MaxFieldsPerIFD: 3},
+			message: "IFD 0 has 10 fields, only the first 3 were read, 7 further fields not loaded",
+			check: func(t *testing.T, doc *Document) {
+				ifd := doc.IFDs[0]
+				if len(ifd.Children) != 3 || ifd.NotLoaded != 7 || ifd.Next != 0 {
+					t.Errorf("read %v fields with %v not loaded and next %v", len(ifd.Children), ifd.NotLoaded, ifd.Next)
+				}
+			},
+		},
+		{
+			name:    "ifds",
+			limits:  Limits{MaxIFDs: 1},
+			message: "the limit of 1 IFDs was reached",
+			check: func(t *testing.T, doc *Document) {
+				if len(doc.IFDs) != 1 {
+					t.Errorf("read %v ifds expected 1", len(doc.IFDs))
+				}
+			},
+		},
+		{
+			name:   "sections",
+			limits: Limits{MaxSections: 16},
+			// the first strip is found but there is no room left for it, the second is not even looked for so its size
+			// is not known
+			message: "2 further parts of the file were skipped, 16 further bytes not loaded",
+			check: func(t *testing.T, doc *Document) {
+				if len(doc.Data) != 0 || len(doc.Offsets) != 3 {
+					t.Errorf("got %v strips and %v offsets", len(doc.Data), len(doc.Offsets))
+				}
+			},
+		},
+		{
+			name:    "shared strip offsets",
+			limits:  Limits{MaxSections: 300},
+			message: "the limit of 300 sections was reached",
+			build:   sharedStripsTIFF,
+			check: func(t *testing.T, doc *Document) {
+				if len(doc.IFDs) != 20 {
+					t.Fatalf("read %v ifds expected 20", len(doc.IFDs))
+				}
+				if len(doc.Data) == 0 || len(doc.Data) > 300 {
+					t.Errorf("placed %v strips", len(doc.Data))
+				}
+				// the shared arrays are only read once
+				first := findField(t, doc.IFDs[0], 273).Offset
+				for _, ifd := range doc.IFDs[1:] {
+					if o := findField(t, ifd, 273).Offset; &o.Data[0] != &first.Data[0] {
+						t.Errorf("the strip offsets of %v were read again", ifd.Name())
+					}
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := stripTIFF(binary.LittleEndian, false)
+			if test.build != nil {
+				b = test.build()
+			}
+			if test.limits.MaxIFDs > 0 {
+				b.setNext(b.ifds[0], b.ifd([]testEntry{b.short(256, 4)}, 0))
+			}
+			doc, err := ParseWithLimits(b.reader(), test.limits)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			found := false
+			for _, d := range doc.Diagnostics {
+				if d.Severity != Warning {
+					t.Errorf("unexpected diagnostic %v", d)
+				}
+				found = found || strings.Contains(d.Message, test.message)
+			}
+			if !found {
+				t.Errorf("expected a diagnostic saying %q, got %v", test.message, doc.Diagnostics)
+			}
+			test.check(t, doc)
+			checkCovered(t, doc, int64(len(b.bytes())))
+			if _, err := RenderHTML(doc); err != nil {
+				t.Errorf("could not render: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseIFDDefaultLimits(t *testing.T) {
+	header := &Header{Endian: binary.LittleEndian, OffsetSize: 4}
+	// more fields than the default limit, which are all empty
+	in := make([]byte, 2+12*0xffff+4)
+	binary.LittleEndian.PutUint16(in, 0xffff)
+
+	ifd, _, _, _, err := ParseIFD(bytes.NewReader(in), 0, header)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uint64(len(ifd.Children)) != DefaultLimits.MaxFieldsPerIFD || ifd.NotLoaded != 0xffff-DefaultLimits.MaxFieldsPerIFD {
+		t.Errorf("read %v fields with %v not loaded", len(ifd.Children), ifd.NotLoaded)
+	}
+}
+
+func TestDataPreview(t *testing.T) {
+	b := newTestBuilder(binary.BigEndian, false)
+	block := make([]byte, 300)
+	for i := range block {
+		block[i] = byte(i)
+	}
+	small := b.add(bytes.Repeat([]byte{7}, 100))
+	large := b.add(block)
+	b.setFirstIFD(b.ifd([]testEntry{
+		b.short(256, 10),
+		b.short(257, 40),
+		b.short(258, 8),
+		b.long(273, uint32(small), uint32(large)),
+		b.short(278, 10),
+		b.short(279, 100, 300),
+	}, 0))
+
+	doc := parseTest(t, b)
+	if len(doc.Data) != 2 {
+		t.Fatalf("got %v strips expected 2", len(doc.Data))
+	}
+	if d := doc.Data[0]; !bytes.Equal(d.Head, bytes.Repeat([]byte{7}, 100)) || d.Tail != nil {
+		t.Errorf("small strip should be shown whole, got %v and %v", d.Head, d.Tail)
+	}
+	if d := doc.Data[1]; !bytes.Equal(d.Head, block[:dataPreviewSize]) || !bytes.Equal(d.Tail, block[300-dataPreviewSize:]) {
+		t.Errorf("large strip should show both ends, got %v and %v", d.Head, d.Tail)
+	}
+
+	sections, err := doc.Data[1].render()
+	if err != nil {
+		t.Fatalf("could not render: %v", err)
+	}
+	hidden := fmt.Sprintf(`data-start="%v" data-end="%v"`, large+dataPreviewSize, large+300-dataPreviewSize)
+	if html := string(sections[0].Data()); !strings.Contains(html, hidden) || !strings.Contains(html, "172 bytes not shown") {
+		t.Errorf("the hidden middle should be described, got %v", html)
+	}
+}
+
+func TestParseGaps(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra []byte
+		kind  GapKind
+	}{
+		{"zeros", make([]byte, 300), ZeroPadding},
+		{"text", append([]byte("written by hand  "), 0, 0), TextGap},
+		{"short text", []byte("ab"), UnknownGap},
+		{"unknown", []byte{1, 2, 3, 0xff, 'a'}, UnknownGap},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := stripTIFF(binary.LittleEndian, false)
+			end := int64(len(b.bytes()))
+			b.buf = append(b.buf, test.extra...)
+
+			doc := parseTest(t, b)
+			gaps := doc.Root.Gaps()
+			if len(gaps) != 1 || gaps[0].Start != end {
+				t.Fatalf("expected one gap at %v, got %v", end, gaps)
+			}
+			gap := doc.Gaps[gaps[0]]
+			if gap == nil || gap.Kind != test.kind {
+				t.Fatalf("gap was %v expected %v", gap, test.kind)
+			}
+			if shown := append(append([]byte{}, gap.Head...), gap.Tail...); len(test.extra) <= 2*dataPreviewSize && !bytes.Equal(shown, test.extra) {
+				t.Errorf("gap shows %v expected %v", shown, test.extra)
+			}
+			if _, err := RenderHTML(doc); err != nil {
+				t.Errorf("could not render: %v", err)
+			}
+		})
+	}
+
+	t.Run("alignment", func(t *testing.T) {
+		b := newTestBuilder(binary.LittleEndian, false)
+		first := b.ifd([]testEntry{
+			b.short(256, 3),
+			b.short(257, 1),
+			b.short(258, 8),
+			b.ascii(270, "abcd"),
+			b.long(273, 0),
+			b.short(278, 1),
+			b.short(279, 3),
+		}, 0)
+		b.setFirstIFD(first)
+		// the description is 5 bytes so the strip after it is moved on a byte
+		strip := b.add([]byte{1, 2, 3})
+		b.setFieldValue(first, 4, uint64(strip))
+		b.buf = append(b.buf, 0)
+
+		doc := parseTest(t, b)
+		gaps := doc.Root.Gaps()
+		if len(gaps) != 2 || gaps[0].End != strip || gaps[1].Start != strip+3 {
+			t.Fatalf("expected a byte of padding either side of the strip, got %v", gaps)
+		}
+		gap := doc.Gaps[gaps[0]]
+		if gap.Kind != AlignmentPadding || gap.After != doc.Offsets[0] {
+			t.Errorf("gap was %v after %v", gap.Kind, gap.After)
+		}
+		// image data is not padded so a byte after it is not alignment
+		if gap := doc.Gaps[gaps[1]]; gap.Kind != ZeroPadding || gap.After != doc.Data[0] {
+			t.Errorf("gap was %v after %v", gap.Kind, gap.After)
+		}
+	})
+
+	t.Run("too long to scan", func(t *testing.T) {
+		b := stripTIFF(binary.LittleEndian, false)
+		b.buf = append(b.buf, make([]byte, maxGapScan+1)...)
+
+		doc := parseTest(t, b)
+		gaps := doc.Root.Gaps()
+		if len(gaps) != 1 {
+			t.Fatalf("expected one gap, got %v", gaps)
+		}
+		gap := doc.Gaps[gaps[0]]
+		if gap.Kind != UnknownGap || !gap.Unscanned || len(gap.Head) != dataPreviewSize || len(gap.Tail) != dataPreviewSize {
+			t.Errorf("gap was %v, unscanned %v, with %v and %v bytes shown", gap.Kind, gap.Unscanned, len(gap.Head), len(gap.Tail))
+		}
+		if sections := renderUnknown(gaps[0], gap); !strings.Contains(string(sections[0].Description()), "not scanned as it is larger than 1MB") {
+			t.Errorf("the gap should say it was not scanned, got %v", sections[0].Description())
+		}
+	})
+}
+
+func TestParseSharedValues(t *testing.T) {
+	b := newTestBuilder(binary.LittleEndian, false)
+	second := b.ifd([]testEntry{b.short(258, 8, 8, 8)}, 0)
+	first := b.ifd([]testEntry{b.short(258, 8, 8, 8)}, second)
+	b.setFirstIFD(first)
+	// point the second ifd at the bits per sample of the first
+	shared := b.order.Uint32(b.bytes()[b.fieldStart(first, 0)+8:])
+	b.setFieldValue(second, 0, uint64(shared))
+
+	doc := parseTest(t, b)
+	if len(doc.Diagnostics) != 0 {
+		t.Errorf("shared values should not be a problem: %v", doc.Diagnostics)
+	}
+	if len(doc.Offsets) != 2 {
+		t.Fatalf("found %v offsets expected 2", len(doc.Offsets))
+	}
+	placed, alias := doc.Offsets[0], doc.Offsets[1]
+	if aliases := placed.Shared().Aliases; len(aliases) != 1 || aliases[0] != alias {
+		t.Errorf("bits per sample should be shared between the ifds, got %v", aliases)
+	}
+	if alias.IFD.Name() != "IFD 1" {
+		t.Errorf("the alias should belong to the second ifd not %v", alias.IFD.Name())
+	}
+	if _, err := RenderHTML(doc); err != nil {
+		t.Errorf("could not render: %v", err)
+	}
+}
+
+func TestParseSparseTiles(t *testing.T) {
+	b := tiledTIFF(binary.LittleEndian)
+	doc := parseTest(t, b)
+	field := findField(t, doc.IFDs[0], 324)
+	counts := findField(t, doc.IFDs[0], 325)
+
+	b.patchOffset(field.Offset.Start, 0)
+	copy(b.buf[counts.Offset.Start:], b.longs(0))
+
+	doc = parseTest(t, b)
+	if len(doc.Diagnostics) != 0 {
+		t.Errorf("sparse tiles should not be a problem: %v", doc.Diagnostics)
+	}
+	if d := doc.Data[0]; d.Start != 0 || d.End != 0 {
+		t.Errorf("sparse tile was %v to %v", d.Start, d.End)
+	}
+}
+
+func TestParseBadHeader(t *testing.T) {
+	doc, err := Parse(bytes.NewReader([]byte("not a tiff file at all")))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if doc == nil || len(doc.Diagnostics) != 1 || doc.Header != nil {
+		t.Fatalf("expected a document with just a diagnostic")
+	}
+	if nodes := doc.Nodes(); len(nodes) != 1 {
+		t.Errorf("expected the whole file to be unknown, got %v nodes", len(nodes))
+	} else if _, ok := nodes[0].(*parser.Unknown); !ok {
+		t.Errorf("expected the whole file to be unknown, got a %v", reflect.TypeOf(nodes[0]))
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	// blocks that should still be shown even though where they are in the image can not be worked out
+	placed := map[string]int{
+		"zero tile width":      12,
+		"zero image length":    2,
+		"missing image length": 1,
+	}
+	for name, data := range malformedTIFFs() {
+		t.Run(name, func(t *testing.T) {
+			doc, err := Parse(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(doc.Diagnostics) == 0 {
+				t.Errorf("expected the problem to be reported")
+			}
+			if want, ok := placed[name]; ok {
+				if len(doc.Data) != want {
+					t.Fatalf("got %v blocks expected %v", len(doc.Data), want)
+				}
+				nodes := map[Node]bool{}
+				for _, n := range doc.Nodes() {
+					nodes[n] = true
+				}
+				for _, d := range doc.Data {
+					if !nodes[d] || d.Located {
+						t.Errorf("block %v should be shown without a position, placed %v located %v", d.I, nodes[d], d.Located)
+					}
+				}
+			}
+			if _, err := RenderHTML(doc); err != nil {
+				t.Errorf("could not render: %v", err)
+			}
+		})
+	}
+}
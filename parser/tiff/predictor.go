@@ -0,0 +1,242 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/emilyselwood/tiffhax/parser/tiff/constants"
+	"math"
+)
+
+// values of the Predictor field (317)
+const (
+	predictorNone          = 1
+	predictorHorizontal    = 2
+	predictorFloatingPoint = 3
+)
+
+// the longest row that is kept while decoding to undo the floating point predictor, which needs a whole row.
+const maxPredictorRow = 64 * 1024
+
+// how many sample values are listed from the start of a block.
+const maxSampleValues = 8
+
+/*
+predictorLayout is what is needed to undo a predictor. Each row of the block is predicted on its own, with samples
+predicted from the same sample of the pixel before.
+*/
+type predictorLayout struct {
+	predictor uint64
+	// rowBytes is the size of a decoded row, 0 when the width of the block is not known.
+	rowBytes int64
+	// stride is how many samples are in a pixel, 1 for planar blocks which only hold one sample.
+	stride int
+	bits   uint64
+	format uint64
+}
+
+/*
+predictorLayout works out how the rows of the block are laid out for the predictor, returning false when there is no
+predictor to undo.
+*/
+func (d *Data) predictorLayout() (predictorLayout, bool) {
+	layout := predictorLayout{predictor: predictorNone, stride: 1, bits: 1, format: 1}
+	if value, err := d.IFD.FieldValue(317); err == nil {
+		layout.predictor = value
+	}
+	if layout.predictor == predictorNone {
+		return layout, false
+	}
+
+	samples := uint64(1)
+	if value, err := d.IFD.FieldValue(277); err == nil && value > 0 {
+		samples = value
+	}
+	planar := false
+	if value, err := d.IFD.FieldValue(284); err == nil && value == 2 {
+		planar = true
+	}
+	if !planar && samples <= maxPredictorRow {
+		layout.stride = int(samples)
+	}
+	if field, err := d.IFD.FindField(258); err == nil {
+		if bits := field.DecodedValues().Unsigned; len(bits) > 0 {
+			layout.bits = bits[0]
+			if planar && d.Plane < uint64(len(bits)) {
+				layout.bits = bits[d.Plane]
+			}
+		}
+	}
+	if field, err := d.IFD.FindField(339); err == nil {
+		if formats := field.DecodedValues().Unsigned; len(formats) > 0 {
+			layout.format = formats[0]
+			if planar && d.Plane < uint64(len(formats)) {
+				layout.format = formats[d.Plane]
+			}
+		}
+	}
+	if width, _, ok := d.pixelSize(); ok {
+		rowBytes := divideRoundUp(multiplySaturating(multiplySaturating(width, uint64(layout.stride)), layout.bits), 8)
+		if rowBytes <= math.MaxInt64/2 {
+			layout.rowBytes = int64(rowBytes)
+		}
+	}
+	return layout, true
+}
+
+/*
+keep is how many decoded bytes need to be kept to show the start of the block with the predictor undone. The floating
+point predictor can only be undone a whole row at a time.
+*/
+func (p predictorLayout) keep() int64 {
+	if p.predictor == predictorFloatingPoint && p.rowBytes > 0 && p.rowBytes <= maxPredictorRow {
+		return (decodedPreviewSize + p.rowBytes - 1) / p.rowBytes * p.rowBytes
+	}
+	return decodedPreviewSize
+}
+
+/*
+undo reverses the predictor on the start of the decoded data, so the samples it really holds can be shown.
+*/
+func (p predictorLayout) undo(s *decodeState, order binary.ByteOrder) {
+	result := s.result
+	result.Predictor = constants.FieldValueLookup[317][uint32(p.predictor)]
+	size := int(p.bits / 8)
+
+	switch p.predictor {
+	case predictorHorizontal:
+		if p.bits%8 != 0 || (size != 1 && size != 2 && size != 4 && size != 8) {
+			s.problem("uses horizontal differencing with %v bit samples, which can only be undone for 8, 16, 32 and 64 bit samples", p.bits)
+			return
+		}
+		if p.rowBytes == 0 {
+			s.note("the width of the block is not known so horizontal differencing can not be undone")
+			return
+		}
+		result.Samples = undoHorizontal(result.Preview, p.rowBytes, p.stride, size, order)
+	case predictorFloatingPoint:
+		if p.bits%8 != 0 || (size != 2 && size != 3 && size != 4 && size != 8) {
+			s.problem("uses the floating point predictor with %v bit samples, which can only be undone for 16, 24, 32 and 64 bit samples", p.bits)
+			return
+		}
+		if p.format != 3 {
+			s.problem("uses the floating point predictor but its sample format is %v not floating point", p.format)
+		}
+		switch {
+		case p.rowBytes == 0:
+			s.note("the width of the block is not known so the floating point predictor can not be undone")
+			return
+		case p.rowBytes > maxPredictorRow:
+			s.note("rows of %v bytes are too long to undo the floating point predictor to show the samples", p.rowBytes)
+			return
+		case int64(len(result.Preview)) < p.rowBytes:
+			s.note("the block decodes to less than a row of %v bytes so the floating point predictor can not be undone", p.rowBytes)
+			return
+		}
+		for row := int64(0); row+p.rowBytes <= int64(len(result.Preview)); row += p.rowBytes {
+			result.Samples = append(result.Samples, undoFloatingPoint(result.Preview[row:row+p.rowBytes], p.stride, size, order)...)
+		}
+	default:
+		s.problem("has an unknown predictor %v", p.predictor)
+		return
+	}
+
+	if len(result.Samples) > decodedPreviewSize {
+		result.Samples = result.Samples[:decodedPreviewSize]
+	}
+	result.Values = sampleValues(result.Samples, size, p.format, order)
+}
+
+/*
+undoHorizontal reverses horizontal differencing, where each sample is stored as the difference from the same sample of
+the pixel before it in the row.
+*/
+func undoHorizontal(data []byte, rowBytes int64, stride int, size int, order binary.ByteOrder) []byte {
+	result := append([]byte{}, data...)
+	distance := int64(stride * size)
+	for row := int64(0); row < int64(len(result)); row += rowBytes {
+		end := min64(row+rowBytes, int64(len(result)))
+		for i := row + distance; i+int64(size) <= end; i += int64(size) {
+			value := readSample(result[i:], size, order) + readSample(result[i-distance:], size, order)
+			writeSample(result[i:], size, order, value)
+		}
+	}
+	return result
+}
+
+/*
+undoFloatingPoint reverses the floating point predictor on one row. The bytes of the row are differenced one at a time
+from the same byte of the pixel before, after the samples were split up so all their most significant bytes come first,
+then the next most significant and so on. The samples are put back together in the byte order of the file.
+*/
+func undoFloatingPoint(row []byte, stride int, size int, order binary.ByteOrder) []byte {
+	shuffled := append([]byte{}, row...)
+	for i := stride; i < len(shuffled); i++ {
+		shuffled[i] += shuffled[i-stride]
+	}
+
+	count := len(shuffled) / size
+	result := make([]byte, count*size)
+	for i := 0; i < count; i++ {
+		for b := 0; b < size; b++ {
+			if order == binary.BigEndian {
+				result[i*size+b] = shuffled[b*count+i]
+			} else {
+				result[i*size+size-1-b] = shuffled[b*count+i]
+			}
+		}
+	}
+	return result
+}
+
+func readSample(b []byte, size int, order binary.ByteOrder) uint64 {
+	switch size {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(order.Uint16(b))
+	case 4:
+		return uint64(order.Uint32(b))
+	}
+	return order.Uint64(b)
+}
+
+func writeSample(b []byte, size int, order binary.ByteOrder, value uint64) {
+	switch size {
+	case 1:
+		b[0] = byte(value)
+	case 2:
+		order.PutUint16(b, uint16(value))
+	case 4:
+		order.PutUint32(b, uint32(value))
+	default:
+		order.PutUint64(b, value)
+	}
+}
+
+/*
+sampleValues reads the first few samples as numbers using the sample format (339), nil when they are a size we can not
+read.
+*/
+func sampleValues(samples []byte, size int, format uint64, order binary.ByteOrder) []string {
+	if size != 1 && size != 2 && size != 4 && size != 8 {
+		return nil
+	}
+	var values []string
+	for i := 0; i+size <= len(samples) && len(values) < maxSampleValues; i += size {
+		value := readSample(samples[i:], size, order)
+		switch {
+		case format == 2:
+			shift := uint(64 - size*8)
+			values = append(values, fmt.Sprint(int64(value<<shift)>>shift))
+		case format == 3 && size == 4:
+			values = append(values, fmt.Sprint(math.Float32frombits(uint32(value))))
+		case format == 3 && size == 8:
+			values = append(values, fmt.Sprint(math.Float64frombits(value)))
+		case format == 3:
+			return nil
+		default:
+			values = append(values, fmt.Sprint(value))
+		}
+	}
+	return values
+}
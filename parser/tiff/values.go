@@ -0,0 +1,193 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// how many values to show before hiding the rest behind an expand control
+const valuesPreviewLength = 8
+
+/*
+Rational is a RATIONAL or SRATIONAL value. Both halves are held as int64 so the unsigned and signed versions fit.
+*/
+type Rational struct {
+	Numerator   int64
+	Denominator int64
+}
+
+/*
+IsValid is false when the denominator is zero and the rational does not have a value.
+*/
+func (r Rational) IsValid() bool {
+	return r.Denominator != 0
+}
+
+/*
+String shows the fraction along with its decimal value.
+*/
+func (r Rational) String() string {
+	fraction := strconv.FormatInt(r.Numerator, 10) + "/" + strconv.FormatInt(r.Denominator, 10)
+	if !r.IsValid() {
+		return fraction + " (zero denominator)"
+	}
+	return fraction + " = " + strconv.FormatFloat(float64(r.Numerator)/float64(r.Denominator), 'g', -1, 64)
+}
+
+/*
+Values holds the decoded values of a field. Which slice is filled in depends on the data type, unsigned integers
+(including bytes and IFD offsets), signed integers, floats or rationals.
+*/
+type Values struct {
+	DType     uint16
+	Unsigned  []uint64
+	Signed    []int64
+	Floats    []float64
+	Rationals []Rational
+}
+
+/*
+DecodeValues turns the raw bytes of a field into typed values using the data type and byte order. Any trailing bytes
+that do not make up a whole value are ignored.
+*/
+func DecodeValues(data []byte, dtype uint16, order binary.ByteOrder) Values {
+	result := Values{DType: dtype}
+	switch dtype {
+	case 1, 7: // byte, undefined
+		for _, b := range data {
+			result.Unsigned = append(result.Unsigned, uint64(b))
+		}
+	case 3: // short
+		for i := 0; i+2 <= len(data); i += 2 {
+			result.Unsigned = append(result.Unsigned, uint64(order.Uint16(data[i:i+2])))
+		}
+	case 4, 13: // long, ifd
+		for i := 0; i+4 <= len(data); i += 4 {
+			result.Unsigned = append(result.Unsigned, uint64(order.Uint32(data[i:i+4])))
+		}
+	case 16, 18: // long8, ifd8
+		for i := 0; i+8 <= len(data); i += 8 {
+			result.Unsigned = append(result.Unsigned, order.Uint64(data[i:i+8]))
+		}
+	case 6: // sbyte
+		for _, b := range data {
+			result.Signed = append(result.Signed, int64(int8(b)))
+		}
+	case 8: // sshort
+		for i := 0; i+2 <= len(data); i += 2 {
+			result.Signed = append(result.Signed, int64(int16(order.Uint16(data[i:i+2]))))
+		}
+	case 9: // slong
+		for i := 0; i+4 <= len(data); i += 4 {
+			result.Signed = append(result.Signed, int64(int32(order.Uint32(data[i:i+4]))))
+		}
+	case 17: // slong8
+		for i := 0; i+8 <= len(data); i += 8 {
+			result.Signed = append(result.Signed, int64(order.Uint64(data[i:i+8])))
+		}
+	case 11: // float
+		for i := 0; i+4 <= len(data); i += 4 {
+			result.Floats = append(result.Floats, float64(math.Float32frombits(order.Uint32(data[i:i+4]))))
+		}
+	case 12: // double
+		for i := 0; i+8 <= len(data); i += 8 {
+			result.Floats = append(result.Floats, math.Float64frombits(order.Uint64(data[i:i+8])))
+		}
+	case 5: // rational
+		for i := 0; i+8 <= len(data); i += 8 {
+			result.Rationals = append(result.Rationals, Rational{
+				Numerator:   int64(order.Uint32(data[i : i+4])),
+				Denominator: int64(order.Uint32(data[i+4 : i+8])),
+			})
+		}
+	case 10: // srational
+		for i := 0; i+8 <= len(data); i += 8 {
+			result.Rationals = append(result.Rationals, Rational{
+				Numerator:   int64(int32(order.Uint32(data[i : i+4]))),
+				Denominator: int64(int32(order.Uint32(data[i+4 : i+8]))),
+			})
+		}
+	}
+	return result
+}
+
+/*
+Len is the number of decoded values.
+*/
+func (v Values) Len() int {
+	return len(v.Unsigned) + len(v.Signed) + len(v.Floats) + len(v.Rationals)
+}
+
+/*
+Strings formats each value for display.
+*/
+func (v Values) Strings() []string {
+	var result []string
+	for _, u := range v.Unsigned {
+		result = append(result, strconv.FormatUint(u, 10))
+	}
+	for _, s := range v.Signed {
+		result = append(result, strconv.FormatInt(s, 10))
+	}
+	for _, f := range v.Floats {
+		if v.DType == 11 {
+			result = append(result, strconv.FormatFloat(f, 'g', -1, 32))
+		} else {
+			result = append(result, strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	}
+	for _, r := range v.Rationals {
+		result = append(result, r.String())
+	}
+	return result
+}
+
+/*
+renderValues lists the values, hiding everything after the first few behind an expand control so long arrays do not
+swamp the page.
+*/
+func renderValues(v Values) template.HTML {
+	values := v.Strings()
+	for i := range values {
+		values[i] = template.HTMLEscapeString(values[i])
+	}
+	// only one of the slices is filled in so the rationals are the whole list when there are any
+	for i, r := range v.Rationals {
+		if !r.IsValid() {
+			values[i] = "<span class=\"rational_invalid\">" + values[i] + "</span>"
+		}
+	}
+	return renderValueList(values)
+}
+
+/*
+renderValueList joins already escaped values, hiding everything after the first few behind an expand control.
+*/
+func renderValueList(values []string) template.HTML {
+	if len(values) <= valuesPreviewLength {
+		return template.HTML(strings.Join(values, ", "))
+	}
+
+	var result bytes.Buffer
+	result.WriteString(strings.Join(values[:valuesPreviewLength], ", "))
+	result.WriteString("<details><summary>and ")
+	result.WriteString(strconv.Itoa(len(values) - valuesPreviewLength))
+	result.WriteString(" more</summary>")
+	result.WriteString(strings.Join(values[valuesPreviewLength:], ", "))
+	result.WriteString("</details>")
+	return template.HTML(result.String())
+}
+
+/*
+isRational says if the data type is a RATIONAL or SRATIONAL, which are two longs.
+*/
+func isRational(dtype uint16) bool {
+	return dtype == 5 || dtype == 10
+}
+
+// the halves of a rational are coloured separately
+var rationalClasses = []string{"rational_numerator", "rational_denominator"}
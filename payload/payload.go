@@ -7,9 +7,22 @@ import (
 )
 
 type Payload struct {
-	Title    string
-	FileName string
-	Sections []Section
+	Title       string
+	FileName    string
+	Sections    []Section
+	Diagnostics []Diagnostic
+	// Live is set when the page is served by tiffhax, so that more of the file can be loaded from it on demand.
+	Live bool
+}
+
+/*
+Diagnostic is a problem found while parsing a file. Link is the id of the section that shows the problem.
+*/
+type Diagnostic struct {
+	Severity string
+	Offset   int64
+	Link     string
+	Message  string
 }
 
 type Section interface {
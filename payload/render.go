@@ -24,16 +24,43 @@ func RenderTemplate(t string, data interface{}, funcMap template.FuncMap) (strin
 }
 
 func RenderByteBlocks(target io.StringWriter, in []byte, length int, classes []string) {
+	renderByteBlocks(target, in, length, classes, -1)
+}
+
+/*
+RenderAnchoredByteBlocks works like RenderByteBlocks but gives each block an id based on its position in the file, so
+that other sections can link to an exact value. See ByteAnchor.
+*/
+func RenderAnchoredByteBlocks(target io.StringWriter, in []byte, length int, classes []string, start int64) {
+	renderByteBlocks(target, in, length, classes, start)
+}
+
+/*
+ByteAnchor is the id of a block rendered by RenderAnchoredByteBlocks that starts at the given position in the file.
+*/
+func ByteAnchor(position int64) string {
+	return "b" + strconv.FormatInt(position, 10)
+}
+
+func renderByteBlocks(target io.StringWriter, in []byte, length int, classes []string, anchorStart int64) {
 	done := false
 	start := 0
 	end := length
+	if end > len(in) {
+		end = len(in)
+	}
 	i := 0
+	block := 0
 	for !done {
-		if i > 0 {
+		if block > 0 {
 			_, _ = target.WriteString(" ")
 		}
 		_, _ = target.WriteString("<span class=\"")
-		_, _ = target.WriteString(classes[i % len(classes)])
+		_, _ = target.WriteString(classes[block % len(classes)])
+		if anchorStart >= 0 {
+			_, _ = target.WriteString("\" id=\"")
+			_, _ = target.WriteString(ByteAnchor(anchorStart + int64(start)))
+		}
 		_, _ = target.WriteString("\">")
 		for j, b := range in[start:end] {
 			if j > 0 {
@@ -48,6 +75,7 @@ func RenderByteBlocks(target io.StringWriter, in []byte, length int, classes []s
 		}
 		_, _ = target.WriteString("</span>")
 
+		block++
 		start = end
 		end = end + length
 		if end > len(in) {
@@ -98,6 +126,98 @@ func RenderBytes(in []byte) string {
 	return strings.ToUpper(buffer.String())
 }
 
+/*
+RenderHexDump displays an array of bytes in hex, 16 to a row, with each row followed by the same bytes as ascii. Bytes
+that are not printable are shown as dots.
+*/
+func RenderHexDump(in []byte) string {
+	var buffer bytes.Buffer
+	for start := 0; start < len(in); start += 16 {
+		end := start + 16
+		if end > len(in) {
+			end = len(in)
+		}
+		row := in[start:end]
+		for i, b := range row {
+			if i > 0 {
+				buffer.WriteString(" ")
+			}
+			buffer.WriteString(strings.ToUpper(RenderByte(b)))
+		}
+		// line the text up with the rows above
+		buffer.WriteString(strings.Repeat("&nbsp;&nbsp;&nbsp;", 16-len(row)))
+		buffer.WriteString("&nbsp;&nbsp;<span class=\"ascii\">")
+		for _, b := range row {
+			if b == ' ' {
+				// so runs of spaces are not collapsed
+				buffer.WriteString("&nbsp;")
+			} else if b > 0x20 && b < 0x7f {
+				buffer.WriteString(template.HTMLEscapeString(string(rune(b))))
+			} else {
+				buffer.WriteString(".")
+			}
+		}
+		buffer.WriteString("</span><br />")
+	}
+	return buffer.String()
+}
+
+/*
+Annotation marks a range of bytes with a css class and a title to show when the mouse is over them. Start is the first
+byte and End is one past the last.
+*/
+type Annotation struct {
+	Start int64
+	End   int64
+	Class string
+	Title string
+}
+
+/*
+RenderAnnotatedBytes displays an array of bytes in hex like RenderBytes, wrapping the bytes covered by each annotation
+in a span. start is the position of the first byte in the same terms as the annotations, which must not overlap and
+must be in order.
+*/
+func RenderAnnotatedBytes(in []byte, start int64, annotations []Annotation) string {
+	var buffer bytes.Buffer
+	next := 0
+	open := false
+	for i, b := range in {
+		position := start + int64(i)
+		if open && position >= annotations[next].End {
+			buffer.WriteString("</span>")
+			open = false
+			next++
+		}
+		for !open && next < len(annotations) && annotations[next].End <= position {
+			next++
+		}
+		if i > 0 {
+			if i%16 == 0 {
+				buffer.WriteString("<br />")
+			} else {
+				buffer.WriteString(" ")
+			}
+		}
+		if !open && next < len(annotations) && annotations[next].Start <= position {
+			buffer.WriteString("<span class=\"")
+			buffer.WriteString(template.HTMLEscapeString(annotations[next].Class))
+			buffer.WriteString("\" title=\"")
+			buffer.WriteString(template.HTMLEscapeString(annotations[next].Title))
+			buffer.WriteString("\">")
+			open = true
+		}
+		buffer.WriteString(strings.ToUpper(RenderByte(b)))
+	}
+	if open {
+		buffer.WriteString("</span>")
+	}
+	if len(in)%16 == 0 && len(in) > 0 {
+		buffer.WriteString("<br />")
+	}
+	return buffer.String()
+}
+
 func RenderByte(in byte) string {
 	result := strconv.FormatInt(int64(in), 16)
 	if len(result) < 2 {
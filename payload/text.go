@@ -0,0 +1,263 @@
+package payload
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const ansiReset = "\x1b[0m"
+
+/*
+ansiColours maps the css classes used by the html template to the closest ansi colours, so the terminal output looks
+like the browser output.
+*/
+var ansiColours = map[string]string{
+	"header_endian":           "\x1b[30;42m",
+	"ifd_header":              "\x1b[30;42m",
+	"ifd_footer":              "\x1b[30;42m",
+	"field_id":                "\x1b[30;42m",
+	"offset_a":                "\x1b[30;42m",
+	"rational_numerator":      "\x1b[30;42m",
+	"header_magic":            "\x1b[30;41m",
+	"field_type":              "\x1b[30;41m",
+	"offset_b":                "\x1b[30;41m",
+	"header_offset":           "\x1b[30;46m",
+	"field_count":             "\x1b[30;46m",
+	"offset_c":                "\x1b[30;46m",
+	"rational_denominator":    "\x1b[30;46m",
+	"field_value":             "\x1b[97;44m",
+	"header_bytesize":         "\x1b[30;43m",
+	"geokey_header":           "\x1b[30;43m",
+	"header_reserved":         "\x1b[30;47m",
+	"rational_invalid":        "\x1b[97;101m",
+	"region_conflict":         "\x1b[30;43m",
+	"region_shared":           "\x1b[3m",
+	"past_end":                "\x1b[30;41m",
+	"not_loaded":              "\x1b[30;47m",
+	"packbits_literal_header": "\x1b[30;46m",
+	"packbits_literal":        "\x1b[36m",
+	"packbits_run":            "\x1b[30;42m",
+	"packbits_noop":           "\x1b[30;47m",
+	"decode_problem":          "\x1b[30;41m",
+	"lzw_clear":               "\x1b[30;46m",
+	"lzw_eoi":                 "\x1b[30;42m",
+	"lzw_invalid":             "\x1b[97;101m",
+	"zlib_cmf":                "\x1b[30;46m",
+	"zlib_flg":                "\x1b[30;44m",
+	"zlib_dictionary":         "\x1b[30;43m",
+	"zlib_adler":              "\x1b[30;42m",
+	"jpeg_image":              "\x1b[30;42m",
+	"jpeg_tables":             "\x1b[30;46m",
+	"jpeg_frame":              "\x1b[30;44m",
+	"jpeg_scan":               "\x1b[30;45m",
+	"jpeg_entropy":            "\x1b[36m",
+	"jpeg_app":                "\x1b[30;47m",
+	"jpeg_unknown":            "\x1b[30;43m",
+	"ifd_kind":                "\x1b[1m",
+}
+
+var (
+	tagPattern   = regexp.MustCompile(`(?s)<(/?)([a-zA-Z]+)([^>]*?)(/?)>`)
+	classPattern = regexp.MustCompile(`class="([^"]*)"`)
+	hrefPattern  = regexp.MustCompile(`href="#b?([^"]*)"`)
+	spacePattern = regexp.MustCompile(`\s+`)
+	ansiPattern  = regexp.MustCompile("\x1b\\[[0-9;]*m")
+)
+
+/*
+RenderText writes the sections as a table of offset, hex and description columns for reading in a terminal. When
+colour is set the css classes are turned into ansi colours, otherwise the output is plain text for piping into other
+tools. Links are shown as "-> @offset".
+*/
+func RenderText(w io.Writer, p Payload, colour bool) error {
+	out := bufio.NewWriter(w)
+
+	_, _ = fmt.Fprintln(out, p.FileName)
+
+	offsetWidth := len("Offset")
+	for _, s := range p.Sections {
+		if l := len(s.Offset()); l > offsetWidth {
+			offsetWidth = l
+		}
+	}
+	// 16 bytes of hex with spaces between them, wider when there are rows with the bytes as text after them
+	dataWidth := 47
+	data := make([][]string, len(p.Sections))
+	for i, s := range p.Sections {
+		data[i] = htmlToText(s.Data(), colour)
+		for _, line := range data[i] {
+			if l := visibleWidth(line); l > dataWidth {
+				dataWidth = l
+			}
+		}
+	}
+
+	writeRow(out, []string{"Offset"}, []string{"Data (Hex)"}, []string{"Description"}, offsetWidth, dataWidth)
+	for i, s := range p.Sections {
+		writeRow(out, []string{s.Offset()}, data[i], htmlToText(s.Description(), colour), offsetWidth, dataWidth)
+	}
+
+	if len(p.Diagnostics) > 0 {
+		_, _ = fmt.Fprintln(out)
+		_, _ = fmt.Fprintln(out, "Diagnostics")
+		for _, d := range p.Diagnostics {
+			_, _ = fmt.Fprintf(out, "%v at %v -> @%v: %v\n", d.Severity, d.Offset, d.Link, d.Message)
+		}
+	}
+
+	return out.Flush()
+}
+
+func writeRow(out io.Writer, offset []string, data []string, desc []string, offsetWidth int, dataWidth int) {
+	rows := len(offset)
+	if len(data) > rows {
+		rows = len(data)
+	}
+	if len(desc) > rows {
+		rows = len(desc)
+	}
+
+	for i := 0; i < rows; i++ {
+		line := pad(cell(offset, i), offsetWidth) + " | " + pad(cell(data, i), dataWidth) + " | " + cell(desc, i)
+		_, _ = fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+}
+
+func cell(lines []string, i int) string {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return ""
+}
+
+func visibleWidth(s string) int {
+	return len([]rune(ansiPattern.ReplaceAllString(s, "")))
+}
+
+// pad works on the visible width so that colour codes do not throw the columns out.
+func pad(s string, width int) string {
+	visible := visibleWidth(s)
+	if visible >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-visible)
+}
+
+/*
+htmlToText converts the small amount of html used in the sections into lines of text. Spans become colours, links
+have their target appended and line breaks split the lines.
+*/
+func htmlToText(in template.HTML, colour bool) []string {
+	var lines []string
+	var line strings.Builder
+	var styles []string
+	var links []string
+	skip := 0
+
+	currentStyle := func() string {
+		return strings.Join(styles, "")
+	}
+	writeText := func(text string) {
+		if skip > 0 {
+			return
+		}
+		// non breaking spaces are only there to line things up in html
+		text = strings.ReplaceAll(html.UnescapeString(spacePattern.ReplaceAllString(text, " ")), "\u00a0", " ")
+		if line.Len() == 0 || strings.HasSuffix(ansiPattern.ReplaceAllString(line.String(), ""), " ") {
+			text = strings.TrimLeft(text, " ")
+		}
+		line.WriteString(text)
+	}
+	newLine := func() {
+		if colour && len(styles) > 0 {
+			line.WriteString(ansiReset)
+		}
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+		line.Reset()
+		if colour {
+			line.WriteString(currentStyle())
+		}
+	}
+
+	source := string(in)
+	last := 0
+	for _, match := range tagPattern.FindAllStringSubmatchIndex(source, -1) {
+		writeText(source[last:match[0]])
+		last = match[1]
+
+		closing := source[match[2]:match[3]] == "/"
+		name := strings.ToLower(source[match[4]:match[5]])
+		attributes := source[match[6]:match[7]]
+		selfClosing := source[match[8]:match[9]] == "/"
+
+		switch name {
+		case "br":
+			newLine()
+		case "div":
+			if closing {
+				newLine()
+			}
+		case "span":
+			if closing {
+				if len(styles) > 0 {
+					styles = styles[:len(styles)-1]
+				}
+				if colour {
+					line.WriteString(ansiReset + currentStyle())
+				}
+			} else {
+				style := ""
+				if class := classPattern.FindStringSubmatch(attributes); class != nil {
+					style = ansiColours[class[1]]
+				}
+				styles = append(styles, style)
+				if colour {
+					line.WriteString(style)
+				}
+			}
+		case "a":
+			// some templates close links with <a/>
+			if closing || selfClosing {
+				if len(links) > 0 {
+					if target := links[len(links)-1]; target != "" {
+						writeText(" -> @" + target)
+					}
+					links = links[:len(links)-1]
+				}
+			} else {
+				target := ""
+				if href := hrefPattern.FindStringSubmatch(attributes); href != nil {
+					target = href[1]
+				}
+				links = append(links, target)
+			}
+		case "summary", "button":
+			// the summary is just the label for the hidden values, which are all shown in text, and there is nothing
+			// to press in text
+			if closing {
+				skip--
+			} else {
+				skip++
+			}
+		case "details":
+			if !closing {
+				writeText(", ")
+			}
+		}
+	}
+	writeText(source[last:])
+
+	if ansiPattern.ReplaceAllString(line.String(), "") != "" || len(lines) == 0 {
+		if colour && len(styles) > 0 {
+			line.WriteString(ansiReset)
+		}
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+	}
+
+	return lines
+}
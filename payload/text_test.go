@@ -0,0 +1,98 @@
+package payload
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       template.HTML
+		colour   bool
+		expected []string
+	}{
+		{
+			name:     "tags",
+			in:       `A field called <span class="field_id">ImageWidth</span> is <b>1</b> value`,
+			expected: []string{"A field called ImageWidth is 1 value"},
+		},
+		{
+			name:     "entities",
+			in:       `&lt;a&gt; &amp; &#34;quoted&#34;&nbsp;text`,
+			expected: []string{`<a> & "quoted" text`},
+		},
+		{
+			name:     "white space",
+			in:       "split\n   over   lines ",
+			expected: []string{"split over lines"},
+		},
+		{
+			name:     "links",
+			in:       `points to <a href="#b42">the values</a> and <a href="#8">IFD 0</a>`,
+			expected: []string{"points to the values -> @42 and IFD 0 -> @8"},
+		},
+		{
+			name:     "line breaks",
+			in:       `first<br />second<br/>third`,
+			expected: []string{"first", "second", "third"},
+		},
+		{
+			name:     "colour",
+			in:       `<span class="field_id">01 00</span> <span class="field_value">04</span>`,
+			colour:   true,
+			expected: []string{"\x1b[30;42m01 00\x1b[0m \x1b[97;44m04\x1b[0m"},
+		},
+		{
+			name:     "colour over lines",
+			in:       `<span class="field_id">a<br />b</span>`,
+			colour:   true,
+			expected: []string{"\x1b[30;42ma\x1b[0m", "\x1b[30;42mb\x1b[0m"},
+		},
+		{
+			name:     "no colour",
+			in:       `<span class="field_id">01 00</span> <span class="field_value">04</span>`,
+			expected: []string{"01 00 04"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := htmlToText(test.in, test.colour)
+			if strings.Join(lines, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("got %q expected %q", lines, test.expected)
+			}
+		})
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	p := Payload{
+		FileName: "test.tif",
+		Sections: []Section{&General{
+			Start:   0,
+			End:     1,
+			TheData: `<span class="header_endian">49 49</span>`,
+			Text:    `Little endian, see <a href="#b4">the offset</a>`,
+		}},
+		Diagnostics: []Diagnostic{{Severity: "error", Offset: 4, Link: "8", Message: "something is wrong"}},
+	}
+
+	for _, colour := range []bool{false, true} {
+		var out bytes.Buffer
+		if err := RenderText(&out, p, colour); err != nil {
+			t.Fatalf("could not render: %v", err)
+		}
+		text := out.String()
+		for _, part := range []string{"test.tif", "Offset", "0 .. 1", "49 49", "Little endian, see the offset -> @4", "error at 4 -> @8: something is wrong"} {
+			if !strings.Contains(text, part) {
+				t.Errorf("colour %v: expected %q in %q", colour, part, text)
+			}
+		}
+		if hasColour := strings.Contains(text, "\x1b["); hasColour != colour {
+			t.Errorf("colour %v but the output has colours %v: %q", colour, hasColour, text)
+		}
+	}
+}
@@ -1,57 +1,185 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"github.com/emilyselwood/tiffhax/parser/tiff"
 	"github.com/emilyselwood/tiffhax/payload"
 	"github.com/skratchdot/open-golang/open"
 	"html/template"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"time"
+	"path/filepath"
+	"strconv"
 )
 
-func parseFile(filePath string) payload.Payload {
+func parseFile(filePath string, limits tiff.Limits) payload.Payload {
 	f, err := os.Open(filePath)
 	if err != nil {
 		log.Fatalf("Could not open file: %s", err)
 	}
 	defer f.Close()
 
-	sections, err := tiff.ParseFile(f)
+	result := payload.Payload{Title: "tiff hax", FileName: filePath}
+
+	doc, err := tiff.ParseWithLimits(f, limits)
 	if err != nil {
 		log.Printf("Could not parse: %s", err)
 	}
+	if doc == nil {
+		result.Diagnostics = []payload.Diagnostic{{Severity: "error", Message: err.Error()}}
+		return result
+	}
+	if len(doc.Diagnostics) > 0 {
+		log.Printf("Found %v problems while parsing", len(doc.Diagnostics))
+	}
 
-	return payload.Payload{
-		Title: "tiff hax", FileName: filePath, Sections: sections,
+	result.Sections, err = tiff.RenderHTML(doc)
+	if err != nil {
+		log.Printf("Could not render: %s", err)
 	}
+	result.Diagnostics = tiff.RenderDiagnostics(doc)
+
+	return result
 }
 
-func setupHttpServer(data payload.Payload) net.Listener {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Simply write some test data for now
-		templates, err := template.ParseFiles(
-			"templates/index.template.html",
-		)
+func exportFile(filePath string, limits tiff.Limits) *tiff.Export {
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Fatalf("Could not open file: %s", err)
+	}
+	defer f.Close()
+
+	doc, err := tiff.ParseWithLimits(f, limits)
+	if err != nil {
+		log.Printf("Could not parse: %s", err)
+	}
+	if doc == nil {
+		return &tiff.Export{FileName: filePath, Error: err.Error()}
+	}
+	export := doc.Export()
+	if err != nil {
+		export.Error = err.Error()
+	}
+	export.FileName = filePath
+
+	return export
+}
+
+func writeJSON(path string, export *tiff.Export) error {
+	out := os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
 		if err != nil {
-			log.Fatalf("Could not parse template files %s", err)
+			return fmt.Errorf("could not create output file: %v", err)
 		}
+		defer f.Close()
+		out = f
+	}
 
-		if err := templates.Execute(w, data); err != nil {
-			log.Printf("Error writing template: %s", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return fmt.Errorf("could not write json: %v", err)
+	}
+	return nil
+}
+
+func writeText(path string, data payload.Payload, colour bool) error {
+	out := os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("could not create output file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := payload.RenderText(out, data, colour); err != nil {
+		return fmt.Errorf("could not write text: %v", err)
+	}
+	return nil
+}
+
+// where the page template is, relative to the executable or the working directory.
+const templateFile = "templates/index.template.html"
+
+/*
+parsePage reads the page template from next to the executable, falling back to the working directory for when it is
+run with go run.
+*/
+func parsePage() (*template.Template, error) {
+	path := templateFile
+	if exe, err := os.Executable(); err == nil {
+		if nextTo := filepath.Join(filepath.Dir(exe), templateFile); fileExists(nextTo) {
+			path = nextTo
 		}
+	}
+	templates, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template files %v", err)
+	}
+	return templates, nil
+}
 
-		// Now that the page has been loaded the program can exit once we've given the http handler time to finish its stuff
-		go func() {
-			time.Sleep(5000 *time.Millisecond)
-			os.Exit(0)
-		}()
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func renderPage(w io.Writer, data payload.Payload) error {
+	templates, err := parsePage()
+	if err != nil {
+		return err
+	}
+
+	return templates.Execute(w, data)
+}
 
+/*
+writeReport writes the page to a file. The template is read first so a missing template does not leave an empty report
+behind, and the file is removed if the page could not be written.
+*/
+func writeReport(path string, data payload.Payload) error {
+	templates, err := parsePage()
+	if err != nil {
+		return fmt.Errorf("could not write report: %v", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create report file: %v", err)
+	}
+
+	if err := templates.Execute(f, data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("could not write report: %v", err)
+	}
+
+	return f.Close()
+}
+
+func setupHttpServer(data payload.Payload, f *os.File) net.Listener {
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatalf("Could not stat file: %s", err)
+	}
+
+	data.Live = true
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if err := renderPage(w, data); err != nil {
+			log.Printf("Error writing template: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 	})
+	// the page stays open to load more of the data blocks so the server keeps running until it is stopped.
+	http.HandleFunc("/bytes", serveBytes(f, info.Size()))
 
 	// run the webserver
 	l, err := net.Listen("tcp", "localhost:3000")
@@ -62,8 +190,74 @@ func setupHttpServer(data payload.Payload) net.Listener {
 	return l
 }
 
+// the most bytes that can be asked for in one go from the bytes endpoint
+const maxByteRange = 1 << 20
+
+/*
+serveBytes streams a range of the file as hex so the page can show more of a data block than it was sent with. The range
+is given by the start and end query parameters, end being one past the last byte. Rows of 16 bytes line up with start.
+When the ascii parameter is true each row is followed by its bytes as text.
+*/
+func serveBytes(f io.ReaderAt, size int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start, err := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not read start, %v", err), http.StatusBadRequest)
+			return
+		}
+		end, err := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not read end, %v", err), http.StatusBadRequest)
+			return
+		}
+		if start < 0 || end < start || end > size {
+			http.Error(w, fmt.Sprintf("range %v to %v is not inside the file of %v bytes", start, end, size), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if end-start > maxByteRange {
+			http.Error(w, fmt.Sprintf("range %v to %v is more than %v bytes", start, end, maxByteRange), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+
+		render := payload.RenderBytes
+		if ascii, _ := strconv.ParseBool(r.URL.Query().Get("ascii")); ascii {
+			render = payload.RenderHexDump
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		// whole rows at a time so the line breaks RenderBytes adds stay every 16 bytes
+		buf := make([]byte, 16*256)
+		for position := start; position < end; {
+			chunk := buf
+			if end-position < int64(len(chunk)) {
+				chunk = chunk[:end-position]
+			}
+			n, err := f.ReadAt(chunk, position)
+			if n > 0 {
+				if _, err := io.WriteString(w, render(chunk[:n])); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				log.Printf("Could not read bytes %v to %v: %s", position, end, err)
+				return
+			}
+			position += int64(n)
+		}
+	}
+}
+
 func main() {
 	// set up, get flags etc
+	output := flag.String("o", "", "write a static html report to this file instead of opening a browser")
+	format := flag.String("format", "html", "output format, html, json, text or ansi (text with colours). Everything but html is written to stdout unless -o is given")
+	limits := tiff.DefaultLimits
+	flag.Int64Var(&limits.MaxOffsetBytes, "max-offset-bytes", limits.MaxOffsetBytes, "most bytes to read for the values of a single field, 0 for no limit")
+	flag.IntVar(&limits.MaxIFDs, "max-ifds", limits.MaxIFDs, "most IFDs to read, 0 for no limit")
+	flag.Uint64Var(&limits.MaxFieldsPerIFD, "max-fields", limits.MaxFieldsPerIFD, "most fields to read from each IFD, 0 for no limit")
+	flag.IntVar(&limits.MaxSections, "max-sections", limits.MaxSections, "most parts of the file to show, 0 for no limit")
+	flag.Int64Var(&limits.MaxDecodeBytes, "max-decode-bytes", limits.MaxDecodeBytes, "biggest compressed block to decode, and the most bytes to decode from it, 0 for no limit")
+	flag.Int64Var(&limits.MaxTotalDecodeBytes, "max-total-decode-bytes", limits.MaxTotalDecodeBytes, "most bytes to read and decode across all the blocks, 0 for no limit")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -71,14 +265,46 @@ func main() {
 		log.Fatal("a filename is required")
 	}
 
+	switch *format {
+	case "html":
+	case "text", "ansi":
+		if err := writeText(*output, parseFile(flag.Arg(0), limits), *format == "ansi"); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "json":
+		if err := writeJSON(*output, exportFile(flag.Arg(0), limits)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		flag.PrintDefaults()
+		log.Fatalf("unknown format %v", *format)
+	}
+
 	// open the file and parse it to create the payload information.
-	data := parseFile(flag.Arg(0))
+	data := parseFile(flag.Arg(0), limits)
+
+	if *output != "" {
+		if err := writeReport(*output, data); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// keep the file open so the page can load more of it
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Could not open file: %s", err)
+	}
+	defer f.Close()
 
 	// setup the http server
-	l := setupHttpServer(data)
+	l := setupHttpServer(data, f)
 
 	// The browser can connect now because the listening socket is open.
-	err := open.Start("http://localhost:3000/")
+	log.Println("Serving on http://localhost:3000/ until stopped with ctrl-c")
+	err = open.Start("http://localhost:3000/")
 	if err != nil {
 		log.Println(err)
 	}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/emilyselwood/tiffhax/payload"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestServeBytes(t *testing.T) {
+	content := make([]byte, maxByteRange+100)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	f, err := ioutil.TempFile("", "tiffhax")
+	if err != nil {
+		t.Fatalf("could not create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("could not write temp file: %v", err)
+	}
+	handler := serveBytes(f, int64(len(content)))
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		body   string
+	}{
+		{"range", "start=16&end=40", http.StatusOK, payload.RenderBytes(content[16:40])},
+		{"ascii", "start=16&end=40&ascii=true", http.StatusOK, payload.RenderHexDump(content[16:40])},
+		{"empty", "start=5&end=5", http.StatusOK, ""},
+		{"up to the end", "start=100&end=" + strconv.Itoa(len(content)), http.StatusOK, ""},
+		{"missing start", "end=10", http.StatusBadRequest, ""},
+		{"bad end", "start=0&end=ten", http.StatusBadRequest, ""},
+		{"negative start", "start=-1&end=10", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"end before start", "start=10&end=5", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"end past the file", "start=0&end=" + strconv.Itoa(len(content)+1), http.StatusRequestedRangeNotSatisfiable, ""},
+		{"too big", "start=0&end=" + strconv.Itoa(maxByteRange+1), http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler(recorder, httptest.NewRequest(http.MethodGet, "/bytes?"+test.query, nil))
+			if recorder.Code != test.status {
+				t.Fatalf("got status %v expected %v: %v", recorder.Code, test.status, recorder.Body.String())
+			}
+			if test.body != "" && recorder.Body.String() != test.body {
+				t.Errorf("got %q expected %q", recorder.Body.String(), test.body)
+			}
+		})
+	}
+}